@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/gorilla/mux"
+)
+
+const eventsKeepAliveInterval = 15 * time.Second
+
+// progressHub fans out job progress events received from the worker to the
+// clients streaming them.
+type progressHub struct {
+	mu        sync.Mutex
+	listeners map[string]map[chan job.Event]struct{}
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{
+		listeners: map[string]map[chan job.Event]struct{}{},
+	}
+}
+
+func (h *progressHub) subscribe(jobId string) chan job.Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan job.Event, 16)
+	if h.listeners[jobId] == nil {
+		h.listeners[jobId] = map[chan job.Event]struct{}{}
+	}
+	h.listeners[jobId][ch] = struct{}{}
+	return ch
+}
+
+func (h *progressHub) unsubscribe(jobId string, ch chan job.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.listeners[jobId], ch)
+	if len(h.listeners[jobId]) == 0 {
+		delete(h.listeners, jobId)
+	}
+}
+
+// publish never blocks; when a slow client falls behind its oldest pending
+// event is dropped so the latest progress, including the final event, is
+// always delivered.
+func (h *progressHub) publish(e job.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.listeners[e.JobID] {
+		select {
+		case ch <- e:
+			continue
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (s *apiServer) listenProgress() {
+	for {
+		msg, err := s.progressSub.Receive(s.stopCtx)
+		if err != nil {
+			if s.stopCtx.Err() == nil {
+				s.progressFailed(err)
+			}
+			return
+		}
+		msg.Ack()
+		e, err := job.DecodeEvent(msg)
+		if err != nil {
+			s.logger.Errorf("invalid progress message: %v", err)
+			continue
+		}
+		s.progress.publish(e)
+	}
+}
+
+// progressFailed reports the progress listener stopping for good, as a
+// subscription is not usable after Receive fails. The server is stopped to
+// be restarted with a new one.
+func (s *apiServer) progressFailed(err error) {
+	err = fmt.Errorf("failed to receive progress message: %v", err)
+	s.logger.Error(err.Error())
+	s.progressMu.Lock()
+	s.progressErr = err
+	s.progressMu.Unlock()
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+func jobSnapshotEvent(j *job.Job) job.Event {
+	e := job.Event{
+		JobID:         j.ID,
+		Type:          job.EventStored,
+		State:         j.State,
+		BytesStored:   j.Size,
+		LinesParsed:   j.LinesProcessed + j.LinesRejected,
+		LinesRejected: j.LinesRejected,
+		Error:         j.Error,
+		Time:          j.UpdatedAt,
+	}
+	switch j.State {
+	case job.StateCompleted:
+		e.Type = job.EventCompleted
+	case job.StateFailed:
+		e.Type = job.EventFailed
+	case job.StateDeleting, job.StateDeleted:
+		e.Type = job.EventDeleted
+	}
+	return e
+}
+
+func writeEvent(w http.ResponseWriter, e job.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
+
+func (s *apiServer) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodGet {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.sendError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+	ctx := r.Context()
+
+	// Subscribe before reading the job so no event is lost in between.
+	events := s.progress.subscribe(jobId)
+	defer s.progress.unsubscribe(jobId, events)
+
+	j, err := s.jobs.Get(ctx, jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	snapshot := jobSnapshotEvent(j)
+	if err := writeEvent(w, snapshot); err != nil {
+		logger.Errorf("failed to write event: %v", err)
+		return
+	}
+	flusher.Flush()
+	if snapshot.Final() {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopCtx.Done():
+			return
+		case <-keepAlive.C:
+			// Catch up in case the final event was missed, e.g. published
+			// while this replica was not subscribed yet.
+			if j, err := s.jobs.Get(ctx, jobId); err == nil && j.State.Terminal() {
+				_ = writeEvent(w, jobSnapshotEvent(j))
+				flusher.Flush()
+				return
+			}
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-events:
+			if err := writeEvent(w, e); err != nil {
+				logger.Errorf("failed to write event: %v", err)
+				return
+			}
+			if e.Final() {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+func TestListenProgressFailure(t *testing.T) {
+	ctx := context.Background()
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	// A subscription that is shut down fails every Receive.
+	if err := sub.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stopCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	s := &apiServer{
+		logger:      log.WithField("module", "api"),
+		errs:        make(chan error, 1),
+		bucket:      memblob.OpenBucket(nil),
+		progressSub: sub,
+		stopCtx:     stopCtx,
+	}
+	defer s.bucket.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.listenProgress()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener did not stop")
+	}
+	select {
+	case err := <-s.errs:
+		t.Logf("reported %v", err)
+	default:
+		t.Fatal("failure was not reported")
+	}
+	if err := s.CheckHealth(); err == nil {
+		t.Fatal("health check passed after the listener stopped")
+	}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"gocloud.dev/blob"
+	"gocloud.dev/pubsub"
+)
+
+func (s *apiServer) handleExportDocs(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodGet {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	format := export.FormatCSV
+	if v := r.URL.Query().Get("format"); v != "" {
+		f, err := export.ParseFormat(v)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		format = f
+	}
+
+	ctx := r.Context()
+	j, err := s.jobs.Get(ctx, jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
+	if j.State != job.StateCompleted {
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("job is %s, only completed jobs can be exported", j.State))
+		return
+	}
+
+	resp := &exportResponse{w: w, contentType: format.ContentType(), filename: export.Filename(j, format)}
+	out, err := export.NewWriter(format, resp, j.Schema)
+	if err == nil {
+		err = export.Documents(ctx, s.coll, jobId, out.Write)
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		// An empty export may write nothing at all.
+		resp.start()
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	errorMsg := fmt.Sprintf("failed to export documents: %v", err)
+	logger.Error(errorMsg)
+	if !resp.started {
+		s.sendError(w, queryErrorStatus(err), errorMsg)
+		return
+	}
+	// The status is already sent, abort so the file is not taken as complete.
+	panic(http.ErrAbortHandler)
+}
+
+// exportResponse sends the headers of the export file with its first bytes,
+// so errors found before them can still be sent with an error status.
+type exportResponse struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (r *exportResponse) start() {
+	if r.started {
+		return
+	}
+	r.started = true
+	r.w.Header().Set("Content-Type", r.contentType)
+	r.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", r.filename))
+	r.w.WriteHeader(http.StatusOK)
+}
+
+func (r *exportResponse) Write(p []byte) (int, error) {
+	r.start()
+	return r.w.Write(p)
+}
+
+// exportStatus is an export as returned by the API, with the link to
+// download it once completed.
+type exportStatus struct {
+	*export.Export
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+func newExportStatus(e *export.Export) exportStatus {
+	st := exportStatus{Export: e}
+	if e.State == job.StateCompleted {
+		st.DownloadURL = fmt.Sprintf("/api/exports/%s/download", e.ID)
+	}
+	return st
+}
+
+func (s *apiServer) handleCreateExport(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodPost {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	format := export.FormatCSV
+	if v := r.FormValue("format"); v != "" {
+		f, err := export.ParseFormat(v)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		format = f
+	}
+
+	ctx := r.Context()
+	j, err := s.jobs.Get(ctx, jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
+	if j.State != job.StateCompleted {
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("job is %s, only completed jobs can be exported", j.State))
+		return
+	}
+
+	e := &export.Export{
+		ID:     uuid.NewString(),
+		JobID:  jobId,
+		Owner:  j.Owner,
+		Format: format,
+		State:  job.StateQueued,
+	}
+	if err := s.exports.Create(ctx, e); err != nil {
+		logger.Error(err.Error())
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	msg := &pubsub.Message{
+		Body: []byte(e.ID),
+		Metadata: map[string]string{
+			"eventType": export.EventRequested,
+		},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
+	if err := s.topic.Send(ctx, msg); err != nil {
+		errorMsg := fmt.Sprintf("failed to queue export: %v", err)
+		logger.Error(errorMsg)
+		if _, eerr := s.exports.Transition(ctx, e.ID, job.StateFailed, errorMsg); eerr != nil {
+			logger.Errorf("failed to update export: %v", eerr)
+		}
+		s.sendError(w, http.StatusInternalServerError, errorMsg)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/exports/%s", e.ID))
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(newExportStatus(e))
+}
+
+func (s *apiServer) handleGetExport(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodGet {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	vars := mux.Vars(r)
+
+	e, err := s.exports.Get(r.Context(), vars["exportId"])
+	if errors.Is(err, export.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, e.Owner) {
+		s.sendError(w, http.StatusNotFound, export.ErrNotFound.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newExportStatus(e))
+}
+
+func (s *apiServer) handleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodGet {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	vars := mux.Vars(r)
+
+	ctx := r.Context()
+	e, err := s.exports.Get(ctx, vars["exportId"])
+	if errors.Is(err, export.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, e.Owner) {
+		s.sendError(w, http.StatusNotFound, export.ErrNotFound.Error())
+		return
+	}
+	if e.State != job.StateCompleted {
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("export is %s", e.State))
+		return
+	}
+
+	reader, err := s.bucket.NewReader(ctx, e.Key, nil)
+	if err == nil {
+		defer reader.Close()
+	}
+	var attrs *blob.Attributes
+	if err == nil {
+		attrs, err = s.bucket.Attributes(ctx, e.Key)
+	}
+	if err != nil {
+		errorMsg := fmt.Sprintf("failed to read export: %v", err)
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusInternalServerError, errorMsg)
+		return
+	}
+
+	w.Header().Set("Content-Type", attrs.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attrs.Size, 10))
+	if attrs.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", attrs.ContentDisposition)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil && ctx.Err() == nil {
+		logger.Errorf("failed to send export: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/auth"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"github.com/gorilla/mux"
+	"gocloud.dev/docstore"
+)
+
+func TestExportDocs(t *testing.T) {
+	ctx := context.Background()
+	jobsColl, err := docstore.OpenCollection(ctx, "mem://jobs/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobsColl.Close()
+	jobs := job.NewStore(jobsColl)
+	if err := jobs.Create(ctx, &job.Job{
+		ID:       "a",
+		Filename: "homes.csv",
+		State:    job.StateCompleted,
+		Schema:   []schema.Column{{Name: "price", Type: schema.TypeFloat}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		closed bool
+		code   int
+		body   string
+	}{
+		{"exported", false, http.StatusOK, "price\n10\n"},
+		// Nothing was written yet, so the failure gets its own status.
+		{"failed before output", true, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQueryTestServer(t, map[string]interface{}{"id": "a-1", "jobId": "a", "price": 10.0})
+			s.jobs = jobs
+			if tt.closed {
+				s.coll.Close()
+			}
+			r := httptest.NewRequest(http.MethodGet, "/api/jobs/a/docs/export", nil)
+			r = mux.SetURLVars(r, map[string]string{"jobId": "a"})
+			r = r.WithContext(auth.NewContext(r.Context(), &auth.Key{ID: "root", Scope: auth.ScopeAdmin}))
+			w := httptest.NewRecorder()
+			s.handleExportDocs(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				if got := w.Header().Get("Content-Disposition"); got != "" {
+					t.Fatalf("Content-Disposition %q on an error", got)
+				}
+				return
+			}
+			if w.Body.String() != tt.body {
+				t.Fatalf("body %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"gocloud.dev/docstore"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// Query parameters that are not field filters.
+var reservedParams = map[string]bool{
+	"orderBy": true,
+	"limit":   true,
+	"cursor":  true,
+	"format":  true,
+}
+
+// filterOps maps the operator suffix of a filter parameter, as in
+// price[gte]=10, to its docstore operator.
+var filterOps = map[string]string{
+	"":    "=",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+	"in":  "in",
+}
+
+type filter struct {
+	field  string
+	op     string
+	values []interface{}
+	// post matches an equality filter while reading the results even though
+	// docstore could evaluate it.
+	post bool
+}
+
+// pushable reports whether docstore can evaluate the filter itself. It has no
+// "in" operator and does not accept bools as filter values, those filters
+// are matched while reading the results instead.
+func (f filter) pushable() bool {
+	if f.op == "in" || f.post {
+		return false
+	}
+	_, isBool := f.values[0].(bool)
+	return !isBool
+}
+
+func (f filter) matches(doc map[string]interface{}) bool {
+	v, ok := fieldValue(doc, f.field)
+	if !ok {
+		return false
+	}
+	for _, want := range f.values {
+		if equalValues(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// cursor marks where a page ended: the value of the ordering field in its
+// last document and that document id, which orders documents sharing the
+// same value.
+type cursor struct {
+	Value string      `json:"v"`
+	Type  schema.Type `json:"t"`
+	ID    string      `json:"id"`
+}
+
+func (c *cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(b, c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if v, err := schema.Convert(c.Type, c.Value); err != nil || v == nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// docsQuery is a document query as described by the request query string:
+//
+//	?state=CA&price[gte]=100&city[in]=Boston,Austin&orderBy=-price&limit=50
+//
+// Filter values are typed using the job schema when there is one, and
+// guessed from the value otherwise. Documents without a value for the
+// orderBy field, missing or null, are left out of the results: they have no
+// place in the order of every docstore and can not be paged through.
+type docsQuery struct {
+	filters   []filter
+	columns   map[string]schema.Type
+	orderBy   string
+	direction string
+	limit     int
+	cursor    *cursor
+}
+
+func parseDocsQuery(params url.Values, columns []schema.Column) (*docsQuery, error) {
+	q := &docsQuery{
+		columns:   map[string]schema.Type{"id": schema.TypeString, "jobId": schema.TypeString, "ownerId": schema.TypeString},
+		orderBy:   "id",
+		direction: docstore.Ascending,
+		limit:     defaultQueryLimit,
+	}
+	for _, c := range columns {
+		q.columns[c.Name] = c.Type
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxQueryLimit {
+			return nil, fmt.Errorf("invalid limit: %q, must be between 1 and %d", v, maxQueryLimit)
+		}
+		q.limit = n
+	}
+	if v := params.Get("orderBy"); v != "" {
+		if strings.HasPrefix(v, "-") {
+			q.direction = docstore.Descending
+			v = v[1:]
+		}
+		if v == "" || strings.Contains(v, ".") {
+			return nil, fmt.Errorf("invalid orderBy: %q", params.Get("orderBy"))
+		}
+		q.orderBy = v
+	}
+	if v := params.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		q.cursor = c
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if !reservedParams[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field, op, err := parseFilterKey(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range params[key] {
+			f, err := q.newFilter(field, op, raw)
+			if err != nil {
+				return nil, err
+			}
+			q.filters = append(q.filters, f)
+		}
+	}
+	return q, nil
+}
+
+func parseFilterKey(key string) (string, string, error) {
+	field, suffix := key, ""
+	if i := strings.Index(key, "["); i >= 0 && strings.HasSuffix(key, "]") {
+		field, suffix = key[:i], key[i+1:len(key)-1]
+	}
+	op, ok := filterOps[suffix]
+	if field == "" || !ok {
+		return "", "", fmt.Errorf("invalid filter: %q", key)
+	}
+	return field, op, nil
+}
+
+func (q *docsQuery) newFilter(field, op, raw string) (filter, error) {
+	raws := []string{raw}
+	if op == "in" {
+		raws = strings.Split(raw, ",")
+	}
+	f := filter{field: field, op: op}
+	for _, r := range raws {
+		t, ok := q.columns[field]
+		if !ok {
+			t = schema.Detect(r)
+		}
+		v, err := schema.Convert(t, r)
+		if err != nil {
+			return filter{}, fmt.Errorf("invalid value for %s: %v", field, err)
+		}
+		if v == nil {
+			return filter{}, fmt.Errorf("invalid value for %s: null values cannot be filtered on", field)
+		}
+		if _, isBool := v.(bool); isBool && op != "=" && op != "in" {
+			return filter{}, fmt.Errorf("invalid filter on %s: bool values only support equality", field)
+		}
+		f.values = append(f.values, v)
+	}
+	if op == "in" && len(f.values) == 1 {
+		f.op = "="
+	}
+	return f, nil
+}
+
+// build returns the docstore queries whose results, in order, make up the
+// next page, and the filters left to match on those results.
+//
+// Docstore orders by a single field, so documents sharing a value are
+// ordered by id here: when the previous page ended within such a group, the
+// rest of it is read first, by id, before moving on to the next values.
+func (q *docsQuery) build(coll *docstore.Collection) ([]*docstore.Query, []filter, error) {
+	var post []filter
+	var pushed []filter
+	for _, f := range q.filters {
+		if f.pushable() {
+			pushed = append(pushed, f)
+		} else {
+			post = append(post, f)
+		}
+	}
+	where := func() (*docstore.Query, bool) {
+		dq := coll.Query()
+		ordered := false
+		for _, f := range pushed {
+			dq = dq.Where(docstore.FieldPath(f.field), f.op, f.values[0])
+			ordered = ordered || f.field == q.orderBy
+		}
+		return dq, ordered
+	}
+
+	var queries []*docstore.Query
+	dq, ordered := where()
+	if q.cursor != nil {
+		v, _ := schema.Convert(q.cursor.Type, q.cursor.Value)
+		if q.orderBy != "id" {
+			group, _ := where()
+			group = group.Where(docstore.FieldPath(q.orderBy), "=", v).
+				Where("id", ">", q.cursor.ID).
+				OrderBy("id", docstore.Ascending)
+			if len(post) == 0 {
+				group = group.Limit(q.limit + 1)
+			}
+			queries = append(queries, group)
+		}
+		op := ">"
+		if q.direction == docstore.Descending {
+			op = "<"
+		}
+		dq = dq.Where(docstore.FieldPath(q.orderBy), op, v)
+		ordered = true
+	} else if (len(pushed) > 0 || q.orderBy != "id") && !ordered {
+		// Docstore only orders filtered queries by a field that is filtered
+		// on, so filter it on a bound every value of its type meets. It
+		// leaves out documents without a value, which is done with or
+		// without filters as not every docstore can order them.
+		bound, ok := lowestValue(q.columns[q.orderBy])
+		if !ok && len(pushed) > 0 {
+			return nil, nil, fmt.Errorf("orderBy %s also requires a filter on %s", q.orderBy, q.orderBy)
+		}
+		if ok {
+			dq = dq.Where(docstore.FieldPath(q.orderBy), ">=", bound)
+		}
+	}
+	dq = dq.OrderBy(q.orderBy, q.direction)
+	if q.orderBy == "id" && len(post) == 0 {
+		// One more than the page to know whether there is a next one.
+		dq = dq.Limit(q.limit + 1)
+	}
+	return append(queries, dq), post, nil
+}
+
+func lowestValue(t schema.Type) (interface{}, bool) {
+	switch t {
+	case schema.TypeString:
+		return "", true
+	case schema.TypeInt:
+		return int64(math.MinInt64), true
+	case schema.TypeFloat:
+		return -math.MaxFloat64, true
+	case schema.TypeTimestamp:
+		return time.Time{}, true
+	}
+	return nil, false
+}
+
+// hasOrderValue tells whether doc has a value to be ordered by, see
+// docsQuery. Ids always do.
+func (q *docsQuery) hasOrderValue(doc map[string]interface{}) bool {
+	v, ok := fieldValue(doc, q.orderBy)
+	if !ok || v == nil {
+		return false
+	}
+	if s, isString := v.(string); isString && schema.IsNull(s) {
+		return false
+	}
+	return true
+}
+
+// nextCursor returns the cursor for the page ending with last.
+func (q *docsQuery) nextCursor(last map[string]interface{}) (string, error) {
+	v, _ := fieldValue(last, q.orderBy)
+	value, t, ok := cursorValue(v)
+	if !ok {
+		return "", fmt.Errorf("cannot paginate on %s: value %v is not orderable", q.orderBy, v)
+	}
+	id, _ := last["id"].(string)
+	c := &cursor{Value: value, Type: t, ID: id}
+	return c.encode(), nil
+}
+
+func cursorValue(v interface{}) (string, schema.Type, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, schema.TypeString, !schema.IsNull(v)
+	case int64:
+		return strconv.FormatInt(v, 10), schema.TypeInt, true
+	case int:
+		return strconv.Itoa(v), schema.TypeInt, true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), schema.TypeFloat, true
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), schema.TypeTimestamp, true
+	}
+	return "", "", false
+}
+
+func fieldValue(doc map[string]interface{}, field string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func equalValues(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return a == b
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"gocloud.dev/docstore"
+)
+
+var testColumns = []schema.Column{
+	{Name: "price", Type: schema.TypeFloat},
+	{Name: "city", Type: schema.TypeString},
+	{Name: "pool", Type: schema.TypeBool},
+	{Name: "beds", Type: schema.TypeInt},
+	{Name: "listed", Type: schema.TypeTimestamp},
+}
+
+func TestParseDocsQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		filters   []filter
+		orderBy   string
+		direction string
+		limit     int
+		invalid   bool
+	}{
+		{
+			name:      "defaults",
+			query:     "",
+			orderBy:   "id",
+			direction: docstore.Ascending,
+			limit:     defaultQueryLimit,
+		},
+		{
+			name:      "typed by the schema",
+			query:     "price[gte]=100&city=Boston&beds=3",
+			orderBy:   "id",
+			direction: docstore.Ascending,
+			limit:     defaultQueryLimit,
+			filters: []filter{
+				{field: "beds", op: "=", values: []interface{}{int64(3)}},
+				{field: "city", op: "=", values: []interface{}{"Boston"}},
+				{field: "price", op: ">=", values: []interface{}{100.0}},
+			},
+		},
+		{
+			name:      "guessed without a schema",
+			query:     "rooms[lt]=4&orderBy=-price&limit=50",
+			orderBy:   "price",
+			direction: docstore.Descending,
+			limit:     50,
+			filters: []filter{
+				{field: "rooms", op: "<", values: []interface{}{int64(4)}},
+			},
+		},
+		{
+			name:      "in",
+			query:     "city[in]=Boston,Austin",
+			orderBy:   "id",
+			direction: docstore.Ascending,
+			limit:     defaultQueryLimit,
+			filters: []filter{
+				{field: "city", op: "in", values: []interface{}{"Boston", "Austin"}},
+			},
+		},
+		{
+			name:      "in with one value",
+			query:     "city[in]=Boston",
+			orderBy:   "id",
+			direction: docstore.Ascending,
+			limit:     defaultQueryLimit,
+			filters: []filter{
+				{field: "city", op: "=", values: []interface{}{"Boston"}},
+			},
+		},
+		{
+			name:      "timestamp",
+			query:     "listed[gt]=2021-06-01",
+			orderBy:   "id",
+			direction: docstore.Ascending,
+			limit:     defaultQueryLimit,
+			filters: []filter{
+				{field: "listed", op: ">", values: []interface{}{time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)}},
+			},
+		},
+		{name: "limit too large", query: "limit=1001", invalid: true},
+		{name: "limit zero", query: "limit=0", invalid: true},
+		{name: "nested orderBy", query: "orderBy=a.b", invalid: true},
+		{name: "empty orderBy", query: "orderBy=-", invalid: true},
+		{name: "unknown operator", query: "price[ne]=1", invalid: true},
+		{name: "value of the wrong type", query: "price=cheap", invalid: true},
+		{name: "null value", query: "city=null", invalid: true},
+		{name: "range on a bool", query: "pool[gt]=true", invalid: true},
+		{name: "invalid cursor", query: "cursor=abc", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := parseDocsQuery(params, testColumns)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("query %+v, want an error", q)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.orderBy != tt.orderBy || q.direction != tt.direction || q.limit != tt.limit {
+				t.Fatalf("orderBy %s %s limit %d, want %s %s %d", q.orderBy, q.direction, q.limit, tt.orderBy, tt.direction, tt.limit)
+			}
+			if len(q.filters) != len(tt.filters) {
+				t.Fatalf("filters %+v, want %+v", q.filters, tt.filters)
+			}
+			for i, f := range q.filters {
+				want := tt.filters[i]
+				if f.field != want.field || f.op != want.op || len(f.values) != len(want.values) {
+					t.Fatalf("filter %+v, want %+v", f, want)
+				}
+				for j := range f.values {
+					if !equalValues(f.values[j], want.values[j]) {
+						t.Fatalf("filter %+v, want %+v", f, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBuildDocsQuery(t *testing.T) {
+	coll, err := docstore.OpenCollection(context.Background(), "mem://docs/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	cursor := (&cursor{Value: "10", Type: schema.TypeFloat, ID: "a-2"}).encode()
+
+	tests := []struct {
+		name  string
+		query string
+		// queries is how many docstore queries make up a page, and post the
+		// fields of the filters matched while reading.
+		queries int
+		post    []string
+		invalid bool
+	}{
+		{name: "by id", query: "", queries: 1},
+		{name: "filtered", query: "price[gte]=10&city=Boston", queries: 1},
+		{name: "ordered by a filtered field", query: "orderBy=price&price[gte]=10", queries: 1},
+		{name: "ordered by a typed field", query: "orderBy=price&city=Boston", queries: 1},
+		{name: "in and bool", query: "city[in]=Boston,Austin&pool=true", queries: 1, post: []string{"city", "pool"}},
+		{name: "after a cursor", query: "orderBy=price&cursor=" + cursor, queries: 2},
+		{name: "after a cursor by id", query: "cursor=" + cursor, queries: 1},
+		{name: "ordered by an unknown field", query: "orderBy=rooms&city=Boston", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := parseDocsQuery(params, testColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			queries, post, err := q.build(coll)
+			if tt.invalid {
+				if err == nil {
+					t.Fatal("built, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(queries) != tt.queries {
+				t.Fatalf("%d queries, want %d", len(queries), tt.queries)
+			}
+			var fields []string
+			for _, f := range post {
+				fields = append(fields, f.field)
+			}
+			if !reflect.DeepEqual(fields, tt.post) {
+				t.Fatalf("post filters on %v, want %v", fields, tt.post)
+			}
+			// Docstore rejects queries it can not run when they are read.
+			for _, dq := range queries {
+				iter := dq.Get(context.Background())
+				err := iter.Next(context.Background(), map[string]interface{}{})
+				if err != nil && err != io.EOF {
+					t.Fatalf("invalid query: %v", err)
+				}
+				iter.Stop()
+			}
+		})
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	listed := time.Date(2021, 6, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		orderBy string
+		last    map[string]interface{}
+		value   interface{}
+		invalid bool
+	}{
+		{name: "float", orderBy: "price", last: map[string]interface{}{"id": "a-1", "price": 10.5}, value: 10.5},
+		{name: "int", orderBy: "beds", last: map[string]interface{}{"id": "a-1", "beds": int64(3)}, value: int64(3)},
+		{name: "string", orderBy: "city", last: map[string]interface{}{"id": "a-1", "city": "Boston"}, value: "Boston"},
+		{name: "timestamp", orderBy: "listed", last: map[string]interface{}{"id": "a-1", "listed": listed}, value: listed},
+		{name: "id", orderBy: "id", last: map[string]interface{}{"id": "a-1"}, value: "a-1"},
+		{name: "nested", orderBy: "address.zip", last: map[string]interface{}{"id": "a-1", "address": map[string]interface{}{"zip": "02134"}}, value: "02134"},
+		{name: "bool", orderBy: "pool", last: map[string]interface{}{"id": "a-1", "pool": true}, invalid: true},
+		{name: "missing", orderBy: "price", last: map[string]interface{}{"id": "a-1"}, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &docsQuery{orderBy: tt.orderBy}
+			s, err := q.nextCursor(tt.last)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("cursor %q, want an error", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := decodeCursor(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := schema.Convert(c.Type, c.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalValues(v, tt.value) || c.ID != "a-1" {
+				t.Fatalf("cursor at %v, %s, want %v, a-1", v, c.ID, tt.value)
+			}
+		})
+	}
+}
+
+func TestHasOrderValue(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  map[string]interface{}
+		want bool
+	}{
+		{"value", map[string]interface{}{"price": 10.0}, true},
+		{"zero", map[string]interface{}{"price": 0.0}, true},
+		{"missing", map[string]interface{}{}, false},
+		{"nil", map[string]interface{}{"price": nil}, false},
+		{"empty string", map[string]interface{}{"price": ""}, false},
+		{"null string", map[string]interface{}{"price": "null"}, false},
+	}
+	q := &docsQuery{orderBy: "price"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := q.hasOrderValue(tt.doc); got != tt.want {
+				t.Fatalf("hasOrderValue(%v) = %v, want %v", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/purge"
+	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/alvarowolfx/cloud-native-go/validate"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+)
+
+const (
+	// purgeBatchSize is how many documents are deleted at once by the API.
+	purgeBatchSize = 500
+	// maxInlinePurgeDocuments is the most documents a job may hold to be
+	// deleted while the request waits. Larger jobs are deleted by the
+	// worker.
+	maxInlinePurgeDocuments = 1000
+)
+
+func (s *apiServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleDeleteJob(w, r)
+		return
+	}
+	s.handleGetJob(w, r)
+}
+
+func (s *apiServer) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodGet {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	maxErrors := job.MaxLineErrors
+	if v := r.URL.Query().Get("maxErrors"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid maxErrors: %q", v))
+			return
+		}
+		maxErrors = n
+	}
+
+	j, err := s.jobs.Get(r.Context(), jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
+	if len(j.LineErrors) > maxErrors {
+		j.LineErrors = j.LineErrors[:maxErrors]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+func (s *apiServer) handleJobQuarantine(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodGet {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	vars := mux.Vars(r)
+	jobId := vars["jobId"]
+
+	ctx := r.Context()
+	j, err := s.jobs.Get(ctx, jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
+
+	iter := s.quarantine.Query().Where("jobId", "=", jobId).Get(ctx)
+	defer iter.Stop()
+
+	stream := newDocStream(w, r)
+	err = streamDocuments(ctx, iter, stream)
+	if err == nil {
+		err = stream.close("")
+	}
+	s.endStream(w, r, stream, err)
+}
+
+// handleDeleteJob deletes the file of a job, its documents and exports,
+// keeping the job as a tombstone. Small jobs are deleted right away, others
+// are answered with a 202 while the worker deletes them. Deleting a job
+// again retries a deletion that failed.
+func (s *apiServer) handleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	jobId := mux.Vars(r)["jobId"]
+	ctx := r.Context()
+
+	j, err := s.jobs.Get(ctx, jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
+	if j.State == job.StateDeleted {
+		sendJob(w, http.StatusOK, j)
+		return
+	}
+	inline := purgeInline(j)
+
+	j, err = s.purger.Start(ctx, jobId)
+	if errors.Is(err, job.ErrInvalidTransition) {
+		s.sendError(w, http.StatusConflict, "job is being processed, delete it once it is done")
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Resumable and signed uploads share the id of their job.
+	if err := s.uploads.Delete(ctx, jobId); err != nil && !errors.Is(err, upload.ErrNotFound) {
+		logger.Errorf("failed to delete upload: %v", err)
+	}
+
+	if inline {
+		deleted, err := s.purger.Purge(ctx, jobId)
+		if err == nil {
+			sendJob(w, http.StatusOK, deleted)
+			return
+		}
+		logger.Errorf("failed to delete job, leaving it to the worker: %v", err)
+	}
+	msg := &pubsub.Message{
+		Body: []byte(jobId),
+		Metadata: map[string]string{
+			"eventType": purge.EventRequested,
+		},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
+	if err := s.topic.Send(ctx, msg); err != nil {
+		errorMsg := fmt.Sprintf("failed to queue job deletion: %v", err)
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusInternalServerError, errorMsg)
+		return
+	}
+	sendJob(w, http.StatusAccepted, j)
+}
+
+// handleReprocessJob ingests the stored file of a finished job again, taking
+// the format, delimiter, sheet, schema, schemaName and scan fields of
+// /api/docs/upload to change how it is read. The documents of the previous
+// run are replaced and its summary is kept in the runs of the job.
+func (s *apiServer) handleReprocessJob(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodPost {
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	jobId := mux.Vars(r)["jobId"]
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	j, err := s.jobs.Get(ctx, jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
+	if !j.State.Terminal() || j.State.Deleted() {
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("job is %s, only completed or failed jobs can be reprocessed", j.State))
+		return
+	}
+	if len(j.Children) > 0 {
+		s.sendError(w, http.StatusConflict, "job was expanded into child jobs, reprocess them instead")
+		return
+	}
+
+	opts, err := reprocessParseOptions(r.Form, j.Parse)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	def := j.SchemaDefinition
+	if r.Form.Get("schema") != "" || r.Form.Get("schemaName") != "" {
+		def, err = s.uploadSchema(ctx, r.Form)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	scan, err := s.uploadScan(r.Form.Get("scan"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = s.checkStoredFile(ctx, jobId, &opts, scan)
+	var ve *validate.Error
+	switch {
+	case gcerrors.Code(err) == gcerrors.NotFound:
+		s.sendError(w, http.StatusConflict, "the file of the job is no longer stored")
+		return
+	case errors.As(err, &ve):
+		logger.Error(ve.Error())
+		s.sendValidationReport(w, ve.Report)
+		return
+	case err != nil:
+		errorMsg := fmt.Sprintf("failed to parse file: %v", err)
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusInternalServerError, errorMsg)
+		return
+	}
+
+	j, err = s.jobs.Update(ctx, jobId, func(current *job.Job) error {
+		return current.Reprocess(opts, def)
+	})
+	if errors.Is(err, job.ErrInvalidTransition) {
+		s.sendError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.publishJob(ctx, jobId); err != nil {
+		logger.Error(err.Error())
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sendJob(w, http.StatusAccepted, j)
+}
+
+// reprocessParseOptions changes the options a job file was read with by the
+// format, delimiter and sheet form fields. A new format starts without the
+// delimiter or sheet of the previous one.
+func reprocessParseOptions(form url.Values, current parser.Options) (parser.Options, error) {
+	opts := current
+	if v := form.Get("format"); v != "" {
+		format, err := parser.Detect(v, "", "")
+		if err != nil {
+			return parser.Options{}, err
+		}
+		opts = parser.Options{Format: format}
+	}
+	if _, ok := form["delimiter"]; ok {
+		opts.Delimiter = form.Get("delimiter")
+	}
+	if _, ok := form["sheet"]; ok {
+		opts.Sheet = form.Get("sheet")
+	}
+	return opts, opts.Validate()
+}
+
+// purgeInline tells whether the data of j is small enough to be deleted while
+// the request waits. Jobs that were attempted without completing may hold
+// any number of documents, and archives hold the data of their children.
+func purgeInline(j *job.Job) bool {
+	if len(j.Children) > 0 {
+		return false
+	}
+	if j.Attempts == 0 {
+		return true
+	}
+	return j.State == job.StateCompleted && j.LinesProcessed+j.LinesRejected <= maxInlinePurgeDocuments
+}
+
+func sendJob(w http.ResponseWriter, statusCode int, j *job.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(j)
+}
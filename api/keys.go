@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/alvarowolfx/cloud-native-go/auth"
+	"github.com/gorilla/mux"
+)
+
+// createdKey is a key as returned once created, the only time the key itself
+// is shown.
+type createdKey struct {
+	*auth.Key
+	Secret string `json:"key"`
+}
+
+// handleKeys lists the API keys, or creates one from the name and scope form
+// fields. Keys are managed with admin keys only.
+func (s *apiServer) handleKeys(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.keys.List(ctx)
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if keys == nil {
+			keys = []*auth.Key{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(keys)
+	case http.MethodPost:
+		name := r.FormValue("name")
+		if name == "" {
+			s.sendError(w, http.StatusBadRequest, "missing name")
+			return
+		}
+		scope, err := auth.ParseScope(r.FormValue("scope"))
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		secret, k, err := s.keys.Create(ctx, name, scope)
+		if err != nil {
+			logger.Error(err.Error())
+			s.sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logger.WithField("keyId", k.ID).WithField("scope", k.Scope).Infof("api key created")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(createdKey{Key: k, Secret: secret})
+	default:
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleRevokeKey revokes an API key. The jobs it uploaded are kept, only
+// admin keys reach them afterwards.
+func (s *apiServer) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	k, err := s.keys.Revoke(r.Context(), mux.Vars(r)["keyId"])
+	if errors.Is(err, auth.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logger.WithField("keyId", k.ID).Infof("api key revoked")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(k)
+}
+
+func (s *apiServer) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if k := auth.FromContext(r.Context()); k == nil || !k.Admin() {
+		s.sendError(w, http.StatusForbidden, "requires an admin api key")
+		return false
+	}
+	return true
+}
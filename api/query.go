@@ -2,13 +2,17 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 
+	"github.com/alvarowolfx/cloud-native-go/auth"
+	"github.com/alvarowolfx/cloud-native-go/job"
 	"github.com/gorilla/mux"
 	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
 )
 
 func (s *apiServer) handleQueryDocs(w http.ResponseWriter, r *http.Request) {
@@ -19,21 +23,18 @@ func (s *apiServer) handleQueryDocs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
-	iter := s.coll.Query().Get(ctx)
-	defer iter.Stop()
-
-	records, err := readDocuments(ctx, iter)
+	q, err := parseDocsQuery(r.URL.Query(), nil)
 	if err != nil {
-		s.sendError(w, http.StatusInternalServerError, err.Error())
+		s.sendError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"list": records,
-	})
+	if k := auth.FromContext(r.Context()); k == nil || !k.Admin() {
+		// Docstore matches the owner, so other documents are never read.
+		// Without a schema here, ordering by a column then takes a filter
+		// on it, which querying the documents of a job does not.
+		q.filters = append(q.filters, filter{field: "ownerId", op: "=", values: []interface{}{requestOwner(r)}})
+	}
+	s.sendDocuments(w, r, q)
 }
 
 func (s *apiServer) handleQueryByJobDocs(w http.ResponseWriter, r *http.Request) {
@@ -46,35 +47,207 @@ func (s *apiServer) handleQueryByJobDocs(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	jobId := vars["jobId"]
 
-	ctx := r.Context()
-	iter := s.coll.Query().Where("jobId", "=", jobId).Get(ctx)
-	defer iter.Stop()
-
-	records, err := readDocuments(ctx, iter)
+	j, err := s.jobs.Get(r.Context(), jobId)
+	if errors.Is(err, job.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if !owns(r, j.Owner) {
+		s.sendError(w, http.StatusNotFound, job.ErrNotFound.Error())
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"list": records,
-	})
+	q, err := parseDocsQuery(r.URL.Query(), j.Schema)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.filters = append(q.filters, filter{field: "jobId", op: "=", values: []interface{}{jobId}})
+	s.sendDocuments(w, r, q)
+}
+
+// sendDocuments streams a page of results of q as they are read, followed
+// by the cursor of the next page when there is one. Reading stops when the
+// client goes away, as that cancels the request context.
+func (s *apiServer) sendDocuments(w http.ResponseWriter, r *http.Request, q *docsQuery) {
+	queries, post, err := q.build(s.coll)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stream := newDocStream(w, r)
+	p := &pageReader{q: q, post: post, emit: stream.write}
+	more, err := p.read(r.Context(), queries)
+	next := ""
+	if err == nil && more {
+		next, err = q.nextCursor(p.last)
+	}
+	if err == nil {
+		err = stream.close(next)
+	}
+	s.endStream(w, r, stream, err)
+}
+
+// endStream reports err, with an error status if nothing was written yet.
+// Otherwise the response is aborted so the client does not mistake it for a
+// complete one.
+func (s *apiServer) endStream(w http.ResponseWriter, r *http.Request, stream *docStream, err error) {
+	if err == nil {
+		return
+	}
+	if r.Context().Err() != nil {
+		s.logger.WithField("path", r.URL.Path).Infof("client went away after %d documents", stream.count)
+		return
+	}
+	if !stream.started {
+		s.sendError(w, queryErrorStatus(err), err.Error())
+		return
+	}
+	s.logger.WithField("path", r.URL.Path).Errorf("failed to stream documents: %v", err)
+	panic(http.ErrAbortHandler)
+}
+
+// pageReader reads a page of up to q.limit documents matching post and
+// hands them to emit.
+type pageReader struct {
+	q    *docsQuery
+	post []filter
+	emit func(map[string]interface{}) error
+
+	count int
+	last  map[string]interface{}
+}
+
+// read reads queries in order and reports whether more documents follow the
+// page.
+func (p *pageReader) read(ctx context.Context, queries []*docstore.Query) (bool, error) {
+	for i, dq := range queries {
+		byField := i == len(queries)-1 && p.q.orderBy != "id"
+		more, err := p.readQuery(ctx, dq, byField)
+		if more || err != nil {
+			return more, err
+		}
+	}
+	return false, nil
 }
 
-func readDocuments(ctx context.Context, iter *docstore.DocumentIterator) ([]map[string]interface{}, error) {
-	records := []map[string]interface{}{}
+// readQuery sends the documents of dq. When it is ordered by a field other
+// than id, documents sharing the same value are held back until the value
+// changes, so they can be sent ordered by id.
+func (p *pageReader) readQuery(ctx context.Context, dq *docstore.Query, byField bool) (bool, error) {
+	iter := dq.Get(ctx)
+	defer iter.Stop()
+
+	var group []map[string]interface{}
 	for {
 		r := map[string]interface{}{}
 		err := iter.Next(ctx, r)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, fmt.Errorf("failed to read query: %v", err)
-		} else {
-			records = append(records, r)
+			return false, fmt.Errorf("failed to read query: %w", err)
+		}
+		if !matchesAll(r, p.post) || !p.q.hasOrderValue(r) {
+			continue
+		}
+		if len(group) > 0 && !sameField(r, group[0], p.q.orderBy) {
+			if full, err := p.sendGroup(group); full || err != nil {
+				return full, err
+			}
+			group = nil
+		}
+		if p.count == p.q.limit {
+			return true, nil
+		}
+		if !byField {
+			if err := p.send(r); err != nil {
+				return false, err
+			}
+			continue
+		}
+		group = append(group, r)
+		// Only the lowest ids of the group can still make it into the page.
+		if n := p.q.limit - p.count + 1; len(group) > 2*n {
+			sortByID(group)
+			group = group[:n]
+		}
+	}
+	return p.sendGroup(group)
+}
+
+// sendGroup sends group ordered by id and reports whether the page filled
+// up before all of it was sent.
+func (p *pageReader) sendGroup(group []map[string]interface{}) (bool, error) {
+	sortByID(group)
+	for _, r := range group {
+		if p.count == p.q.limit {
+			return true, nil
+		}
+		if err := p.send(r); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (p *pageReader) send(r map[string]interface{}) error {
+	if err := p.emit(r); err != nil {
+		return err
+	}
+	p.count++
+	p.last = r
+	return nil
+}
+
+func sameField(a, b map[string]interface{}, field string) bool {
+	va, _ := fieldValue(a, field)
+	vb, _ := fieldValue(b, field)
+	return equalValues(va, vb)
+}
+
+func sortByID(records []map[string]interface{}) {
+	sort.Slice(records, func(i, j int) bool {
+		a, _ := records[i]["id"].(string)
+		b, _ := records[j]["id"].(string)
+		return a < b
+	})
+}
+
+func matchesAll(doc map[string]interface{}, filters []filter) bool {
+	for _, f := range filters {
+		if !f.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+// queryErrorStatus maps docstore rejecting a query, like a malformed field
+// path, to a bad request.
+func queryErrorStatus(err error) int {
+	if gcerrors.Code(err) == gcerrors.InvalidArgument {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// streamDocuments writes every document of iter to stream.
+func streamDocuments(ctx context.Context, iter *docstore.DocumentIterator, stream *docStream) error {
+	for {
+		r := map[string]interface{}{}
+		err := iter.Next(ctx, r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read query: %w", err)
+		}
+		if err := stream.write(r); err != nil {
+			return err
 		}
 	}
-	return records, nil
 }
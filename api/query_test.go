@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/auth"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+)
+
+func newQueryTestServer(t *testing.T, docs ...map[string]interface{}) *apiServer {
+	t.Helper()
+	ctx := context.Background()
+	coll, err := docstore.OpenCollection(ctx, "mem://docs/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { coll.Close() })
+	for _, doc := range docs {
+		if err := coll.Put(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &apiServer{logger: log.WithField("module", "api"), coll: coll}
+}
+
+func queryDocs(t *testing.T, s *apiServer, k *auth.Key, target string) (int, []map[string]interface{}) {
+	t.Helper()
+	code, list, _ := queryPage(t, s, k, "", target)
+	return code, list
+}
+
+// queryPage queries the documents of jobId, or of every job when empty.
+func queryPage(t *testing.T, s *apiServer, k *auth.Key, jobId, target string) (int, []map[string]interface{}, string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r = r.WithContext(auth.NewContext(r.Context(), k))
+	w := httptest.NewRecorder()
+	if jobId != "" {
+		r = mux.SetURLVars(r, map[string]string{"jobId": jobId})
+		s.handleQueryByJobDocs(w, r)
+	} else {
+		s.handleQueryDocs(w, r)
+	}
+	var body struct {
+		List       []map[string]interface{} `json:"list"`
+		NextCursor string                   `json:"nextCursor"`
+	}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+		}
+	} else {
+		t.Logf("status %d: %s", w.Code, w.Body.String())
+	}
+	return w.Code, body.List, body.NextCursor
+}
+
+func TestQueryDocsOwner(t *testing.T) {
+	s := newQueryTestServer(t,
+		map[string]interface{}{"id": "a-1", "jobId": "a", "ownerId": "alice", "price": 30.0},
+		map[string]interface{}{"id": "a-2", "jobId": "a", "ownerId": "alice", "price": 10.0},
+		map[string]interface{}{"id": "b-1", "jobId": "b", "ownerId": "bob", "price": 20.0},
+	)
+	alice := &auth.Key{ID: "alice", Scope: auth.ScopeUser}
+	admin := &auth.Key{ID: "root", Scope: auth.ScopeAdmin}
+
+	tests := []struct {
+		name   string
+		key    *auth.Key
+		target string
+		code   int
+		ids    []string
+	}{
+		{"user by id", alice, "/api/docs", http.StatusOK, []string{"a-1", "a-2"}},
+		{"user by filtered column", alice, "/api/docs?orderBy=price&price[gte]=0", http.StatusOK, []string{"a-2", "a-1"}},
+		{"user by filtered column descending", alice, "/api/docs?orderBy=-price&price[gte]=0", http.StatusOK, []string{"a-1", "a-2"}},
+		// Matching the owner while reading would go through every document.
+		{"user by column", alice, "/api/docs?orderBy=price", http.StatusBadRequest, nil},
+		{"user spoofing owner", alice, "/api/docs?ownerId=bob", http.StatusOK, nil},
+		{"admin by column", admin, "/api/docs?orderBy=price", http.StatusOK, []string{"a-2", "b-1", "a-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, list := queryDocs(t, s, tt.key, tt.target)
+			if code != tt.code {
+				t.Fatalf("status %d, want %d", code, tt.code)
+			}
+			var ids []string
+			for _, doc := range list {
+				ids = append(ids, doc["id"].(string))
+			}
+			if len(ids) != len(tt.ids) {
+				t.Fatalf("ids %v, want %v", ids, tt.ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Fatalf("ids %v, want %v", ids, tt.ids)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryDocsWithoutOrderValue(t *testing.T) {
+	s := newQueryTestServer(t,
+		map[string]interface{}{"id": "a-1", "jobId": "a", "ownerId": "alice", "price": 20.0},
+		map[string]interface{}{"id": "a-2", "jobId": "a", "ownerId": "alice", "price": nil},
+		map[string]interface{}{"id": "a-3", "jobId": "a", "ownerId": "alice"},
+		map[string]interface{}{"id": "a-4", "jobId": "a", "ownerId": "alice", "price": 10.0},
+		map[string]interface{}{"id": "a-5", "jobId": "a", "ownerId": "alice", "price": 20.0},
+	)
+	jobsColl, err := docstore.OpenCollection(context.Background(), "mem://jobs/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobsColl.Close()
+	s.jobs = job.NewStore(jobsColl)
+	if err := s.jobs.Create(context.Background(), &job.Job{
+		ID:     "a",
+		Owner:  "alice",
+		State:  job.StateCompleted,
+		Schema: []schema.Column{{Name: "price", Type: schema.TypeFloat}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	alice := &auth.Key{ID: "alice", Scope: auth.ScopeUser}
+
+	tests := []struct {
+		name  string
+		query string
+		ids   []string
+	}{
+		{"ascending", "orderBy=price", []string{"a-4", "a-1", "a-5"}},
+		{"descending", "orderBy=-price", []string{"a-1", "a-5", "a-4"}},
+		{"filtered", "orderBy=price&price[lt]=15", []string{"a-4"}},
+		{"by id", "", []string{"a-1", "a-2", "a-3", "a-4", "a-5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One document a page, so every page ends with a cursor.
+			var ids []string
+			cursor := ""
+			for page := 0; page <= len(tt.ids); page++ {
+				target := "/api/jobs/a/docs?limit=1&" + tt.query
+				if cursor != "" {
+					target += "&cursor=" + url.QueryEscape(cursor)
+				}
+				code, list, next := queryPage(t, s, alice, "a", target)
+				if code != http.StatusOK {
+					t.Fatalf("status %d on page %d, want 200", code, page)
+				}
+				for _, doc := range list {
+					ids = append(ids, doc["id"].(string))
+				}
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if len(ids) != len(tt.ids) {
+				t.Fatalf("ids %v, want %v", ids, tt.ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Fatalf("ids %v, want %v", ids, tt.ids)
+				}
+			}
+		})
+	}
+}
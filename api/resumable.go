@@ -0,0 +1,641 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alvarowolfx/cloud-native-go/cloud"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/alvarowolfx/cloud-native-go/validate"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// Resumable uploads follow the tus protocol (https://tus.io): an upload is
+// created with its length, its chunks are appended with PATCH requests
+// carrying the offset they start at and HEAD tells where to resume from.
+// Unlike tus, the job is only created by a final POST to /finalize.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum"
+
+	contentTypeOffsetOctetStream = "application/offset+octet-stream"
+
+	// statusChecksumMismatch is the tus status of a chunk not matching its
+	// Upload-Checksum header.
+	statusChecksumMismatch = 460
+)
+
+func (s *apiServer) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	w.Header().Set("Tus-Resumable", tusVersion)
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Checksum-Algorithm", "sha256")
+		if s.limits.MaxFileSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.limits.MaxFileSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !s.checkTusVersion(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		s.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid Upload-Length: %q", r.Header.Get("Upload-Length")))
+		return
+	}
+	if s.limits.MaxFileSize > 0 && length > s.limits.MaxFileSize {
+		s.sendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is %d bytes, the limit is %d", length, s.limits.MaxFileSize))
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	def, err := s.uploadSchema(ctx, meta)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts, err := uploadParseOptions(meta, meta.Get("filetype"), meta.Get("filename"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scan, err := s.uploadScan(meta.Get("scan"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expiresAt, err := uploadExpiry(meta.Get("ttl"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	onDuplicate, err := uploadOnDuplicate(meta.Get("onDuplicate"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	uploader := uploadUploader(r, meta.Get("uploader"))
+	u := &upload.Upload{
+		ID:       uuid.NewString(),
+		Filename: meta.Get("filename"),
+		Uploader: uploader,
+		Owner:    requestOwner(r),
+		Length:   length,
+
+		ExpiresAt:        expiresAt,
+		Parse:            opts,
+		Scan:             scan,
+		SchemaDefinition: def,
+		OnDuplicate:      onDuplicate,
+	}
+	if err := s.uploads.Create(ctx, u); err != nil {
+		logger.Errorf("failed to create upload: %v", err)
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(u)
+}
+
+func (s *apiServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	w.Header().Set("Tus-Resumable", tusVersion)
+	uploadId := mux.Vars(r)["uploadId"]
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		s.getUpload(w, r, uploadId)
+	case http.MethodPatch:
+		s.patchUpload(w, r, logger, uploadId)
+	case http.MethodDelete:
+		s.deleteUpload(w, r, logger, uploadId)
+	default:
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// getUpload answers HEAD requests with the offset to resume from, as tus
+// clients expect, and GET requests with the upload as JSON.
+func (s *apiServer) getUpload(w http.ResponseWriter, r *http.Request, uploadId string) {
+	u, err := s.uploads.Get(r.Context(), uploadId)
+	if errors.Is(err, upload.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, u.Owner) {
+		s.sendError(w, http.StatusNotFound, upload.ErrNotFound.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(u)
+}
+
+func (s *apiServer) patchUpload(w http.ResponseWriter, r *http.Request, logger *log.Entry, uploadId string) {
+	if !s.checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != contentTypeOffsetOctetStream {
+		s.sendError(w, http.StatusUnsupportedMediaType, "chunks must be sent as "+contentTypeOffsetOctetStream)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		s.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid Upload-Offset: %q", r.Header.Get("Upload-Offset")))
+		return
+	}
+	checksum, err := parseUploadChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	u, err := s.uploads.Get(ctx, uploadId)
+	if errors.Is(err, upload.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, u.Owner) {
+		s.sendError(w, http.StatusNotFound, upload.ErrNotFound.Error())
+		return
+	}
+	if u.State != upload.StateUploading {
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("upload is %s", u.State))
+		return
+	}
+	if u.Direct {
+		s.sendError(w, http.StatusConflict, "upload is written through its signed URL")
+		return
+	}
+	if offset != u.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("upload is at offset %d", u.Offset))
+		return
+	}
+
+	ctx, span := otel.Tracer("api").Start(ctx, "upload.chunk")
+	defer span.End()
+	// The chunk is stored even when the client goes away while sending it,
+	// which cancels the request context.
+	ctx = trace.ContextWithSpan(context.Background(), span)
+	part, err := s.writePart(ctx, u, r.Body, checksum)
+	if errors.Is(err, errChecksumMismatch) {
+		s.sendError(w, statusChecksumMismatch, err.Error())
+		return
+	}
+	if errors.Is(err, errChunkTooLarge) {
+		s.sendError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil && part == nil {
+		logger.Errorf("failed to store chunk: %v", err)
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err != nil {
+		// Keep what was received before the connection broke, the client
+		// resumes from there.
+		logger.Warnf("chunk of upload %s cut short after %d bytes: %v", uploadId, part.Size, err)
+	}
+
+	if part != nil {
+		u, err = s.uploads.Update(ctx, uploadId, func(u *upload.Upload) error {
+			return u.Append(*part)
+		})
+		if err != nil {
+			if derr := s.bucket.Delete(ctx, part.Key); derr != nil {
+				logger.Errorf("failed to delete chunk %s: %v", part.Key, derr)
+			}
+			status := http.StatusInternalServerError
+			if errors.Is(err, upload.ErrOffsetMismatch) || errors.Is(err, upload.ErrConflict) {
+				status = http.StatusConflict
+			}
+			s.sendError(w, status, err.Error())
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var (
+	errChecksumMismatch = errors.New("chunk does not match its checksum")
+	errChunkTooLarge    = errors.New("chunk goes past the length of the upload")
+)
+
+// writePart stores body as the chunk of u starting at its current offset.
+// When reading body fails, the bytes read until then are still stored and
+// returned along with the error, unless a checksum is to be verified.
+func (s *apiServer) writePart(ctx context.Context, u *upload.Upload, body io.Reader, checksum []byte) (*upload.Part, error) {
+	remaining := u.Length - u.Offset
+	key := upload.PartKey(u.ID, u.Offset)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bw, err := s.bucket.NewWriter(ctx, key, &blob.WriterOptions{ContentType: contentTypeOffsetOctetStream})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save chunk: %v", err)
+	}
+	h := sha256.New()
+	// One byte more than remaining tells chunks that are too large.
+	n, copyErr := io.Copy(bw, io.TeeReader(io.LimitReader(body, remaining+1), h))
+	switch {
+	case n > remaining:
+		copyErr = errChunkTooLarge
+	case checksum != nil && copyErr == nil && !bytes.Equal(h.Sum(nil), checksum):
+		copyErr = errChecksumMismatch
+	}
+	if n == 0 || copyErr == errChunkTooLarge || copyErr == errChecksumMismatch || (copyErr != nil && checksum != nil) {
+		// Cancelling the context before Close discards the blob.
+		cancel()
+		bw.Close()
+		return nil, copyErr
+	}
+	if err := bw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to save chunk: %v", err)
+	}
+	return &upload.Part{Key: key, Offset: u.Offset, Size: n}, copyErr
+}
+
+func (s *apiServer) deleteUpload(w http.ResponseWriter, r *http.Request, logger *log.Entry, uploadId string) {
+	ctx := r.Context()
+	u, err := s.uploads.Get(ctx, uploadId)
+	if errors.Is(err, upload.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, u.Owner) {
+		s.sendError(w, http.StatusNotFound, upload.ErrNotFound.Error())
+		return
+	}
+	if err := s.uploads.Delete(ctx, uploadId); err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.deleteParts(ctx, logger, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) deleteParts(ctx context.Context, logger *log.Entry, u *upload.Upload) {
+	for _, p := range u.Parts {
+		if err := s.bucket.Delete(ctx, p.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			logger.Errorf("failed to delete chunk %s: %v", p.Key, err)
+		}
+	}
+}
+
+// handleFinalizeUpload joins the chunks of a complete upload into the file
+// of a job of the same id, checks it like a direct upload and queues it.
+// Uploads through a signed URL call it once their file is written. Finalizing
+// again returns the job.
+func (s *apiServer) handleFinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("api")
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodPost {
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	uploadId := mux.Vars(r)["uploadId"]
+
+	ctx := r.Context()
+	u, err := s.uploads.Get(ctx, uploadId)
+	if errors.Is(err, upload.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !owns(r, u.Owner) {
+		s.sendError(w, http.StatusNotFound, upload.ErrNotFound.Error())
+		return
+	}
+	if u.State == upload.StateCompleted {
+		j, err := s.jobs.Get(ctx, u.JobID)
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.sendUploadedJob(w, j)
+		return
+	}
+	if u.Direct {
+		attrs, err := s.bucket.Attributes(ctx, u.ID)
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			s.sendError(w, http.StatusConflict, "file was not uploaded to its signed URL yet")
+			return
+		}
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("failed to read file: %v", err))
+			return
+		}
+		u.Length = attrs.Size
+		u.Offset = attrs.Size
+	} else if !u.Complete() {
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("upload is incomplete, %d of %d bytes received", u.Offset, u.Length))
+		return
+	}
+
+	j, err := s.jobs.Get(ctx, u.ID)
+	if errors.Is(err, job.ErrNotFound) {
+		// The job does not exist yet unless a previous finalize stopped
+		// right after creating it. Like the file of a direct upload, the
+		// file is hashed before being written, so that content sent again
+		// is not written at all.
+		parts := uploadParts(u)
+		var sum string
+		sum, err = s.hashParts(ctx, parts)
+		if err != nil {
+			errorMsg := fmt.Sprintf("failed to read file: %v", err)
+			logger.Error(errorMsg)
+			s.sendError(w, http.StatusInternalServerError, errorMsg)
+			return
+		}
+		var existing *job.Job
+		existing, err = s.findDuplicate(ctx, logger, u.Owner, sum, u.OnDuplicate)
+		if err != nil {
+			s.sendDuplicateError(w, logger, err)
+			return
+		}
+		if existing != nil {
+			if u.Direct {
+				if err := s.bucket.Delete(ctx, u.ID); err != nil {
+					logger.Errorf("failed to delete file: %v", err)
+				}
+			}
+			s.completeUpload(ctx, logger, u, existing.ID)
+			s.sendDuplicate(w, existing)
+			return
+		}
+
+		ctx, spanCompose := tracer.Start(ctx, "upload.compose")
+		err = s.composeUpload(ctx, u, parts, sum)
+		spanCompose.End()
+		if err != nil {
+			errorMsg := fmt.Sprintf("failed to save file: %v", err)
+			logger.Error(errorMsg)
+			s.sendError(w, http.StatusInternalServerError, errorMsg)
+			return
+		}
+
+		ctx, spanParse := tracer.Start(ctx, "file.parse")
+		opts := u.Parse
+		err = s.checkStoredFile(ctx, u.ID, &opts, u.Scan)
+		spanParse.End()
+		if err != nil {
+			if derr := s.bucket.Delete(ctx, u.ID); derr != nil {
+				logger.Errorf("failed to delete file: %v", derr)
+			}
+			var ve *validate.Error
+			if errors.As(err, &ve) {
+				logger.Error(ve.Error())
+				s.sendValidationReport(w, ve.Report)
+				return
+			}
+			errorMsg := fmt.Sprintf("failed to parse file: %v", err)
+			logger.Error(errorMsg)
+			s.sendError(w, http.StatusInternalServerError, errorMsg)
+			return
+		}
+
+		j, err = s.submitJob(ctx, &job.Job{
+			ID:       u.ID,
+			Filename: u.Filename,
+			Size:     u.Length,
+			SHA256:   sum,
+			Uploader: u.Uploader,
+			Owner:    u.Owner,
+			State:    job.StateUploaded,
+
+			ExpiresAt: u.ExpiresAt,
+
+			SchemaDefinition: u.SchemaDefinition,
+			Parse:            opts,
+		})
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.completeUpload(ctx, logger, u, j.ID)
+	s.sendUploadedJob(w, j)
+}
+
+// completeUpload records that u was turned into the job jobId, and deletes
+// its chunks.
+func (s *apiServer) completeUpload(ctx context.Context, logger *log.Entry, u *upload.Upload, jobId string) {
+	length := u.Length
+	u, err := s.uploads.Update(ctx, u.ID, func(u *upload.Upload) error {
+		u.State = upload.StateCompleted
+		u.JobID = jobId
+		u.Length = length
+		u.Offset = length
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("failed to complete upload: %v", err)
+		return
+	}
+	s.deleteParts(ctx, logger, u)
+}
+
+// uploadParts returns the pieces the file of u is made of: its chunks, or
+// the file itself when written through a signed URL.
+func uploadParts(u *upload.Upload) []upload.Part {
+	if u.Direct {
+		return []upload.Part{{Key: u.ID, Size: u.Length}}
+	}
+	return u.Parts
+}
+
+// hashParts returns the hex SHA-256 of parts read one after the other.
+func (s *apiServer) hashParts(ctx context.Context, parts []upload.Part) (string, error) {
+	h := sha256.New()
+	for _, p := range parts {
+		if _, err := s.copyPart(ctx, h, p); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// composeUpload writes parts one after the other to the file of the job of
+// u, with sum as its sha256 metadata, checking they add up to its length.
+// The file of a direct upload is rewritten in place, which buckets allow as
+// a write only replaces the file once closed.
+func (s *apiServer) composeUpload(ctx context.Context, u *upload.Upload, parts []upload.Part, sum string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bw, err := s.bucket.NewWriter(ctx, u.ID, &blob.WriterOptions{
+		Metadata: map[string]string{"sha256": sum},
+	})
+	if err != nil {
+		return err
+	}
+	var written int64
+	for _, p := range parts {
+		if p.Offset != written {
+			err = fmt.Errorf("chunk at %d does not follow the previous one, ending at %d", p.Offset, written)
+			break
+		}
+		var n int64
+		n, err = s.copyPart(ctx, bw, p)
+		written += n
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && written != u.Length {
+		err = fmt.Errorf("chunks add up to %d bytes, upload length is %d", written, u.Length)
+	}
+	if err != nil {
+		cancel()
+		bw.Close()
+		return err
+	}
+	return bw.Close()
+}
+
+func (s *apiServer) copyPart(ctx context.Context, w io.Writer, p upload.Part) (int64, error) {
+	r, err := s.bucket.NewReader(ctx, p.Key, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+func (s *apiServer) checkStoredFile(ctx context.Context, key string, opts *parser.Options, scan bool) error {
+	ra, err := cloud.NewBlobReaderAt(ctx, s.bucket, key)
+	if err != nil {
+		return err
+	}
+	return validate.File(ra, ra.Size(), opts, s.limits, scan)
+}
+
+func (s *apiServer) sendUploadedJob(w http.ResponseWriter, j *job.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"id":        j.ID,
+		"totalRead": fmt.Sprintf("%v", j.Size),
+		"size":      fmt.Sprintf("%v", j.Size),
+		"state":     string(j.State),
+	})
+}
+
+// checkTusVersion rejects requests asking for another version of the
+// protocol. Requests not declaring one are accepted.
+func (s *apiServer) checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if v := r.Header.Get("Tus-Resumable"); v != "" && v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		s.sendError(w, http.StatusPreconditionFailed, fmt.Sprintf("unsupported tus version %q", v))
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, comma separated
+// keys each followed by its base64 encoded value. It carries the form fields
+// of a direct upload, like format or schemaName, along with filename and
+// filetype.
+func parseUploadMetadata(h string) (url.Values, error) {
+	meta := url.Values{}
+	if strings.TrimSpace(h) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(h, ",") {
+		kv := strings.Fields(pair)
+		if len(kv) == 0 || len(kv) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata: %q", pair)
+		}
+		value := ""
+		if len(kv) == 2 {
+			b, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %s: %v", kv[0], err)
+			}
+			value = string(b)
+		}
+		meta.Set(kv[0], value)
+	}
+	return meta, nil
+}
+
+// parseUploadChecksum decodes the Upload-Checksum header of a chunk, the
+// algorithm followed by the base64 encoded digest. Only sha256 is supported.
+func parseUploadChecksum(h string) ([]byte, error) {
+	if h == "" {
+		return nil, nil
+	}
+	parts := strings.Fields(h)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid Upload-Checksum: %q", h)
+	}
+	if parts[0] != "sha256" {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q, use sha256", parts[0])
+	}
+	sum, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid Upload-Checksum: %q", h)
+	}
+	return sum, nil
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/auth"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/alvarowolfx/cloud-native-go/validate"
+	"github.com/gorilla/mux"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/docstore"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+// newUploadTestServer returns a server on in-memory collections, topic and
+// bucket, for the upload handlers.
+func newUploadTestServer(t *testing.T) *apiServer {
+	t.Helper()
+	ctx := context.Background()
+	open := func(url string) *docstore.Collection {
+		coll, err := docstore.OpenCollection(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { coll.Close() })
+		return coll
+	}
+	bucket := memblob.OpenBucket(nil)
+	t.Cleanup(func() { bucket.Close() })
+	topic := mempubsub.NewTopic()
+	t.Cleanup(func() { topic.Shutdown(ctx) })
+	return NewServer(open("mem://docs/id"), open("mem://quarantine/id"), job.NewStore(open("mem://jobs/id")), nil, nil,
+		upload.NewStore(open("mem://uploads/id")), nil, topic, nil, bucket, nil, validate.Limits{}, "0", nil).(*apiServer)
+}
+
+// finalize POSTs to the finalize URL of uploadId as the API key owner.
+func finalize(t *testing.T, s *apiServer, owner, uploadId string) (int, map[string]string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/uploads/"+uploadId+"/finalize", nil)
+	r = mux.SetURLVars(r, map[string]string{"uploadId": uploadId})
+	r = r.WithContext(auth.NewContext(r.Context(), &auth.Key{ID: owner, Scope: auth.ScopeUser}))
+	w := httptest.NewRecorder()
+	s.handleFinalizeUpload(w, r)
+	body := map[string]string{}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+		}
+	}
+	return w.Code, body
+}
+
+func TestFinalizeUploadDuplicate(t *testing.T) {
+	ctx := context.Background()
+	const content = "a,b\n1,2\n"
+	h := sha256.Sum256([]byte(content))
+	sum := hex.EncodeToString(h[:])
+	opts, err := uploadParseOptions(url.Values{}, "text/csv", "homes.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		direct      bool
+		onDuplicate string
+		// earlier is the owner of a job with the same content, if any.
+		earlier string
+		code    int
+		reused  bool
+	}{
+		{name: "new content", code: http.StatusOK},
+		{name: "new content through a signed URL", direct: true, code: http.StatusOK},
+		{name: "content of another key", earlier: "other", code: http.StatusOK},
+		{name: "reused", earlier: "k", code: http.StatusOK, reused: true},
+		{name: "reused through a signed URL", direct: true, earlier: "k", code: http.StatusOK, reused: true},
+		{name: "rejected", onDuplicate: duplicateReject, earlier: "k", code: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUploadTestServer(t)
+			if tt.earlier != "" {
+				if err := s.jobs.Create(ctx, &job.Job{ID: "earlier", Owner: tt.earlier, SHA256: sum, State: job.StateCompleted}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			u := &upload.Upload{
+				ID:          "u",
+				Owner:       "k",
+				Length:      int64(len(content)),
+				Offset:      int64(len(content)),
+				Direct:      tt.direct,
+				Parse:       opts,
+				OnDuplicate: tt.onDuplicate,
+			}
+			key := u.ID
+			if !tt.direct {
+				key = upload.PartKey(u.ID, 0)
+				u.Parts = []upload.Part{{Key: key, Size: u.Length}}
+			}
+			if err := s.bucket.WriteAll(ctx, key, []byte(content), nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.uploads.Create(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+
+			code, body := finalize(t, s, "k", u.ID)
+			if code != tt.code {
+				t.Fatalf("status %d, want %d", code, tt.code)
+			}
+			u, err := s.uploads.Get(ctx, u.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != http.StatusOK {
+				if u.State != upload.StateUploading {
+					t.Fatalf("upload %s, want it left uploading", u.State)
+				}
+				return
+			}
+			wantJob := u.ID
+			if tt.reused {
+				wantJob = "earlier"
+			}
+			if body["id"] != wantJob || u.JobID != wantJob || u.State != upload.StateCompleted {
+				t.Fatalf("answered job %s, upload %s of job %s, want job %s", body["id"], u.State, u.JobID, wantJob)
+			}
+			if (body["duplicate"] == "true") != tt.reused {
+				t.Fatalf("duplicate %q", body["duplicate"])
+			}
+			attrs, err := s.bucket.Attributes(ctx, u.ID)
+			if tt.reused {
+				if err == nil {
+					t.Fatal("file of a reused upload kept")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Metadata["sha256"] != sum {
+				t.Fatalf("file sha256 metadata %q, want %q", attrs.Metadata["sha256"], sum)
+			}
+			j, err := s.jobs.Get(ctx, u.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.SHA256 != sum || j.State != job.StateQueued {
+				t.Fatalf("job %s with sha256 %q, want queued with %q", j.State, j.SHA256, sum)
+			}
+			// Finalizing again answers with the same job.
+			if code, body := finalize(t, s, "k", u.ID); code != http.StatusOK || body["id"] != wantJob {
+				t.Fatalf("finalized again with status %d, job %s", code, body["id"])
+			}
+		})
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	ctx := context.Background()
+	s := newUploadTestServer(t)
+	opts, err := uploadParseOptions(url.Values{}, "text/csv", "homes.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upload.Upload{ID: "u", Owner: "k", Length: 8, Parse: opts}
+	if err := s.uploads.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	checksum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return "sha256 " + base64.StdEncoding.EncodeToString(h[:])
+	}
+
+	// Steps run in order on the same upload.
+	steps := []struct {
+		name     string
+		method   string
+		offset   string
+		body     string
+		checksum string
+		code     int
+		// at is the offset of the upload after the step.
+		at string
+	}{
+		{name: "first chunk", method: http.MethodPatch, offset: "0", body: "a,b\n", code: http.StatusNoContent, at: "4"},
+		{name: "resume", method: http.MethodHead, code: http.StatusOK, at: "4"},
+		{name: "finalize incomplete", method: http.MethodPost, code: http.StatusConflict, at: "4"},
+		{name: "chunk sent again", method: http.MethodPatch, offset: "0", body: "a,b\n", code: http.StatusConflict, at: "4"},
+		{name: "chunk past the offset", method: http.MethodPatch, offset: "6", body: "2\n", code: http.StatusConflict, at: "4"},
+		{name: "chunk past the length", method: http.MethodPatch, offset: "4", body: "1,2\n3", code: http.StatusRequestEntityTooLarge, at: "4"},
+		{name: "checksum mismatch", method: http.MethodPatch, offset: "4", body: "1,2\n", checksum: checksum("1,3\n"), code: statusChecksumMismatch, at: "4"},
+		{name: "invalid offset", method: http.MethodPatch, offset: "-1", body: "1,2\n", code: http.StatusBadRequest, at: "4"},
+		{name: "last chunk", method: http.MethodPatch, offset: "4", body: "1,2\n", checksum: checksum("1,2\n"), code: http.StatusNoContent, at: "8"},
+		{name: "finalize", method: http.MethodPost, code: http.StatusOK, at: "8"},
+		{name: "finalize again", method: http.MethodPost, code: http.StatusOK, at: "8"},
+		{name: "chunk after finalize", method: http.MethodPatch, offset: "8", body: "x", code: http.StatusConflict, at: "8"},
+	}
+	for _, step := range steps {
+		if step.method == http.MethodPost {
+			code, body := finalize(t, s, "k", u.ID)
+			if code != step.code {
+				t.Fatalf("%s: status %d, want %d", step.name, code, step.code)
+			}
+			if code == http.StatusOK && body["id"] != u.ID {
+				t.Fatalf("%s: job %s, want %s", step.name, body["id"], u.ID)
+			}
+		} else {
+			r := httptest.NewRequest(step.method, "/api/uploads/"+u.ID, strings.NewReader(step.body))
+			r = mux.SetURLVars(r, map[string]string{"uploadId": u.ID})
+			r = r.WithContext(auth.NewContext(r.Context(), &auth.Key{ID: "k", Scope: auth.ScopeUser}))
+			r.Header.Set("Tus-Resumable", tusVersion)
+			r.Header.Set("Content-Type", contentTypeOffsetOctetStream)
+			r.Header.Set("Upload-Offset", step.offset)
+			if step.checksum != "" {
+				r.Header.Set("Upload-Checksum", step.checksum)
+			}
+			w := httptest.NewRecorder()
+			s.handleUpload(w, r)
+			if w.Code != step.code {
+				t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.code, w.Body.String())
+			}
+			if got := w.Header().Get("Upload-Offset"); got != "" && got != step.at {
+				t.Fatalf("%s: Upload-Offset %s, want %s", step.name, got, step.at)
+			}
+		}
+		current, err := s.uploads.Get(ctx, u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strconv.FormatInt(current.Offset, 10) != step.at {
+			t.Fatalf("%s: upload at %d, want %s", step.name, current.Offset, step.at)
+		}
+	}
+
+	// The chunks are joined into the file of the job and then deleted.
+	content, err := s.bucket.ReadAll(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a,b\n1,2\n" {
+		t.Fatalf("file %q", content)
+	}
+	iter := s.bucket.List(&blob.ListOptions{Prefix: "uploads/"})
+	if obj, err := iter.Next(ctx); err != io.EOF {
+		t.Fatalf("chunk %v left after finalize: %v", obj, err)
+	}
+}
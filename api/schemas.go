@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"github.com/gorilla/mux"
+)
+
+// handleSchemas lists the registered schemas, or registers one under its
+// name, replacing any schema already registered under it.
+func (s *apiServer) handleSchemas(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := s.schemas.List(ctx)
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"list": list,
+		})
+	case http.MethodPost:
+		// Registered schemas are shared by the uploads of every key, so only
+		// admin keys change them.
+		if !s.checkAdmin(w, r) {
+			return
+		}
+		def := &schema.Definition{}
+		if err := json.NewDecoder(r.Body).Decode(def); err != nil {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid schema: %v", err))
+			return
+		}
+		if def.Name == "" {
+			s.sendError(w, http.StatusBadRequest, "invalid schema: missing name")
+			return
+		}
+		if err := def.Validate(); err != nil {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid schema: %v", err))
+			return
+		}
+		if err := s.schemas.Put(ctx, def); err != nil {
+			logger.Error(err.Error())
+			s.sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(def)
+	default:
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+	}
+}
+
+func (s *apiServer) handleGetSchema(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodGet {
+		s.sendError(w, http.StatusMethodNotAllowed, "not allowed")
+		return
+	}
+	vars := mux.Vars(r)
+
+	def, err := s.schemas.Get(r.Context(), vars["name"])
+	if errors.Is(err, schema.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(def)
+}
+
+// uploadSchema resolves the schema definition sent along with an upload,
+// either inline in the schema form field or registered under schemaName.
+func (s *apiServer) uploadSchema(ctx context.Context, form url.Values) (*schema.Definition, error) {
+	if inline := form.Get("schema"); inline != "" {
+		def := &schema.Definition{}
+		if err := json.Unmarshal([]byte(inline), def); err != nil {
+			return nil, fmt.Errorf("invalid schema: %v", err)
+		}
+		if err := def.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid schema: %v", err)
+		}
+		return def, nil
+	}
+	if name := form.Get("schemaName"); name != "" {
+		def, err := s.schemas.Get(ctx, name)
+		if errors.Is(err, schema.ErrNotFound) {
+			return nil, fmt.Errorf("unknown schema %q", name)
+		}
+		return def, err
+	}
+	return nil, nil
+}
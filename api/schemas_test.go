@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/auth"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"github.com/apex/log"
+	"gocloud.dev/docstore"
+)
+
+func TestRegisterSchemaScope(t *testing.T) {
+	coll, err := docstore.OpenCollection(context.Background(), "mem://schemas/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	s := &apiServer{logger: log.WithField("module", "api"), schemas: schema.NewRegistry(coll)}
+
+	tests := []struct {
+		name string
+		key  *auth.Key
+		code int
+	}{
+		{"user", &auth.Key{ID: "alice", Scope: auth.ScopeUser}, http.StatusForbidden},
+		{"admin", &auth.Key{ID: "root", Scope: auth.ScopeAdmin}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"name":"homes","fields":[{"name":"price","type":"float"}]}`
+			r := httptest.NewRequest(http.MethodPost, "/api/schemas", strings.NewReader(body))
+			r = r.WithContext(auth.NewContext(r.Context(), tt.key))
+			w := httptest.NewRecorder()
+			s.handleSchemas(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
@@ -3,9 +3,20 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/alvarowolfx/cloud-native-go/auth"
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/purge"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/alvarowolfx/cloud-native-go/validate"
 	"github.com/apex/log"
 	"github.com/gorilla/mux"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
@@ -13,6 +24,7 @@ import (
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/metric/global"
 	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
 	"gocloud.dev/docstore"
 	"gocloud.dev/pubsub"
 	"gocloud.dev/server"
@@ -21,22 +33,49 @@ import (
 
 type Server interface {
 	Start()
+	Shutdown(ctx context.Context) error
 }
 
 type apiServer struct {
 	port   string
 	errs   chan error
 	logger *log.Entry
+	srv    *server.Server
+
+	// stopCtx is cancelled when the server starts shutting down, ending the
+	// progress listener and any open event streams.
+	stopCtx context.Context
+	stop    context.CancelFunc
 
 	bucket *blob.Bucket
 	topic  *pubsub.Topic
 	coll   *docstore.Collection
+	jobs   *job.Store
+
+	schemas    *schema.Registry
+	quarantine *docstore.Collection
+	exports    *export.Store
+	uploads    *upload.Store
+	purger     *purge.Purger
+	keys       *auth.Store
+
+	// signer verifies the signed URLs of a fileblob bucket, which the API
+	// serves itself. It is nil for buckets whose provider serves them.
+	signer fileblob.URLSigner
+	limits validate.Limits
+
+	progressSub *pubsub.Subscription
+	progress    *progressHub
+	// progressErr is why the progress listener stopped, failing the health
+	// check as events are no longer sent.
+	progressMu  sync.Mutex
+	progressErr error
 
 	totalFileUploaded     metric.Int64Counter
 	totalFileSizeUploaded metric.Int64Counter
 }
 
-func NewServer(coll *docstore.Collection, topic *pubsub.Topic, bucket *blob.Bucket, port string, errs chan error) Server {
+func NewServer(coll, quarantine *docstore.Collection, jobs *job.Store, schemas *schema.Registry, exports *export.Store, uploads *upload.Store, keys *auth.Store, topic *pubsub.Topic, progressSub *pubsub.Subscription, bucket *blob.Bucket, signer fileblob.URLSigner, limits validate.Limits, port string, errs chan error) Server {
 	logger := log.WithField("module", "api")
 
 	meter := global.GetMeterProvider().Meter("github.com/alvarowolfx/cloud-native-go")
@@ -45,16 +84,42 @@ func NewServer(coll *docstore.Collection, topic *pubsub.Topic, bucket *blob.Buck
 	totalFileSizeUploaded, err := meter.NewInt64Counter("api.file.upload.size", metric.WithDescription("total size of file uploaded"))
 	handleOtelErr(err)
 
-	return &apiServer{
+	stopCtx, stop := context.WithCancel(context.Background())
+	s := &apiServer{
 		port:                  port,
 		errs:                  errs,
 		logger:                logger,
 		coll:                  coll,
+		jobs:                  jobs,
+		schemas:               schemas,
+		quarantine:            quarantine,
+		exports:               exports,
+		uploads:               uploads,
+		keys:                  keys,
+		purger:                purge.New(jobs, exports, bucket, coll, quarantine, purgeBatchSize),
 		topic:                 topic,
+		progressSub:           progressSub,
+		progress:              newProgressHub(),
 		bucket:                bucket,
+		signer:                signer,
+		limits:                limits,
 		totalFileUploaded:     totalFileUploaded,
 		totalFileSizeUploaded: totalFileSizeUploaded,
+		stopCtx:               stopCtx,
+		stop:                  stop,
 	}
+	srvOptions := &server.Options{
+		HealthChecks: []health.Checker{s},
+		// No write timeout, job event streams stay open until the job ends.
+		Driver: &server.DefaultDriver{
+			Server: http.Server{
+				ReadTimeout: 30 * time.Second,
+				IdleTimeout: 120 * time.Second,
+			},
+		},
+	}
+	s.srv = server.New(http.DefaultServeMux, srvOptions)
+	return s
 }
 
 func handleOtelErr(err error) {
@@ -70,7 +135,9 @@ func (s *apiServer) CheckHealth() error {
 		s.logger.Error(e.Error())
 		return e
 	}
-	return nil
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	return s.progressErr
 }
 
 func (s *apiServer) sendError(w http.ResponseWriter, statusCode int, errorMsg string) {
@@ -93,23 +160,137 @@ func (s *apiServer) traceMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (s *apiServer) Start() {
-	srvOptions := &server.Options{
-		HealthChecks: []health.Checker{s},
+// authMiddleware requires an API key, given as a bearer token or in the
+// X-API-Key header, and puts it in the request context. Signed URLs are
+// authorized by their signature instead.
+func (s *apiServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/blob" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			key = strings.TrimPrefix(h, "Bearer ")
+		}
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.sendError(w, http.StatusUnauthorized, "missing api key")
+			return
+		}
+		k, err := s.keys.Authenticate(r.Context(), key)
+		if errors.Is(err, auth.ErrInvalidKey) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.sendError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			s.logger.WithField("path", r.URL.Path).Errorf("failed to authenticate request: %v", err)
+			s.sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), k)))
+	})
+}
+
+// owns tells whether the caller of r may reach data tagged with owner. Data
+// it does not own is answered as not found, not giving away that it exists.
+func owns(r *http.Request, owner string) bool {
+	k := auth.FromContext(r.Context())
+	return k != nil && k.Owns(owner)
+}
+
+// requestOwner returns the id of the key of the caller, which the data it
+// creates is tagged with.
+func requestOwner(r *http.Request) string {
+	if k := auth.FromContext(r.Context()); k != nil {
+		return k.ID
 	}
-	srv := server.New(http.DefaultServeMux, srvOptions)
+	return ""
+}
 
+func (s *apiServer) Start() {
 	r := mux.NewRouter()
 	r.Use(s.traceMiddleware)
+	r.Use(s.authMiddleware)
 	r.HandleFunc("/api/docs/upload", s.handleDocsUpload)
+	r.HandleFunc("/api/docs/upload-url", s.handleUploadURL)
+	r.HandleFunc("/api/blob", s.handleSignedBlob)
+	r.HandleFunc("/api/uploads", s.handleCreateUpload)
+	r.HandleFunc("/api/uploads/{uploadId}", s.handleUpload)
+	r.HandleFunc("/api/uploads/{uploadId}/finalize", s.handleFinalizeUpload)
+	r.HandleFunc("/api/jobs/{jobId}", s.handleJob)
+	r.HandleFunc("/api/jobs/{jobId}/events", s.handleJobEvents)
+	r.HandleFunc("/api/jobs/{jobId}/reprocess", s.handleReprocessJob)
+	r.HandleFunc("/api/jobs/{jobId}/quarantine", s.handleJobQuarantine)
+	r.HandleFunc("/api/schemas", s.handleSchemas)
+	r.HandleFunc("/api/schemas/{name}", s.handleGetSchema)
 	r.HandleFunc("/api/{jobId}/docs", s.handleQueryByJobDocs)
+	r.HandleFunc("/api/{jobId}/docs/export", s.handleExportDocs)
+	r.HandleFunc("/api/exports/{exportId}", s.handleGetExport)
+	r.HandleFunc("/api/exports/{exportId}/download", s.handleDownloadExport)
+	r.HandleFunc("/api/{jobId}/exports", s.handleCreateExport)
 	r.HandleFunc("/api/docs", s.handleQueryDocs)
+	r.HandleFunc("/api/keys", s.handleKeys)
+	r.HandleFunc("/api/keys/{keyId}", s.handleRevokeKey)
 
 	http.Handle("/", otelhttp.NewHandler(r, "api"))
+	go s.listenProgress()
 
 	s.logger.Infof("listening on port %s", s.port)
-	err := srv.ListenAndServe(":" + s.port)
-	if err != nil {
+	err := s.srv.ListenAndServe(":" + s.port)
+	if err != nil && err != http.ErrServerClosed {
 		s.errs <- err
 	}
 }
+
+// Shutdown stops accepting requests, waits for in-flight ones to finish
+// until ctx expires and then closes the resources the server was given.
+func (s *apiServer) Shutdown(ctx context.Context) error {
+	s.stop()
+	var errs []error
+	if err := s.srv.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown http server: %v", err))
+	}
+
+	// The deadline may be spent on in-flight requests, closing gets its own.
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.progressSub.Shutdown(closeCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown progress subscription: %v", err))
+	}
+	if err := s.topic.Shutdown(closeCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown topic: %v", err))
+	}
+	if err := s.coll.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close collection: %v", err))
+	}
+	if err := s.quarantine.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close quarantine collection: %v", err))
+	}
+	if err := s.schemas.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close schemas collection: %v", err))
+	}
+	if err := s.jobs.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close jobs collection: %v", err))
+	}
+	if err := s.exports.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close exports collection: %v", err))
+	}
+	if err := s.uploads.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close uploads collection: %v", err))
+	}
+	if err := s.keys.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close api keys collection: %v", err))
+	}
+	if err := s.bucket.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close bucket: %v", err))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs[1:] {
+		s.logger.Error(err.Error())
+	}
+	return errs[0]
+}
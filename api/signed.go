@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/google/uuid"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// signedUploadExpiry is how long a signed upload URL can be used.
+const signedUploadExpiry = time.Hour
+
+// signedUpload tells the client where to write its file and what to call
+// once it is written.
+type signedUpload struct {
+	ID          string    `json:"id"`
+	JobID       string    `json:"jobId"`
+	Method      string    `json:"method"`
+	UploadURL   string    `json:"uploadUrl"`
+	ContentType string    `json:"contentType,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	FinalizeURL string    `json:"finalizeUrl"`
+}
+
+// handleUploadURL returns a signed URL to PUT a file straight to the bucket,
+// taking the same form fields as /api/docs/upload without the file, along
+// with its filename and contentType. The job of the file is created when
+// the client POSTs to the finalize URL after writing it.
+func (s *apiServer) handleUploadURL(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if r.Method != http.MethodPost {
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	def, err := s.uploadSchema(ctx, r.Form)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	contentType := r.Form.Get("contentType")
+	opts, err := uploadParseOptions(r.Form, contentType, r.Form.Get("filename"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scan, err := s.uploadScan(r.Form.Get("scan"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expiresAt, err := uploadExpiry(r.Form.Get("ttl"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	onDuplicate, err := uploadOnDuplicate(r.Form.Get("onDuplicate"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	uploader := uploadUploader(r, r.Form.Get("uploader"))
+	u := &upload.Upload{
+		ID:       uuid.NewString(),
+		Filename: r.Form.Get("filename"),
+		Uploader: uploader,
+		Owner:    requestOwner(r),
+		Direct:   true,
+
+		ExpiresAt:        expiresAt,
+		Parse:            opts,
+		Scan:             scan,
+		SchemaDefinition: def,
+		OnDuplicate:      onDuplicate,
+	}
+	// The file is written where the job created from the upload reads it.
+	uploadURL, err := s.bucket.SignedURL(ctx, u.ID, &blob.SignedURLOptions{
+		Method:      http.MethodPut,
+		Expiry:      signedUploadExpiry,
+		ContentType: contentType,
+	})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		s.sendError(w, http.StatusNotImplemented, "the bucket does not support signed URLs")
+		return
+	}
+	if err != nil {
+		logger.Errorf("failed to sign upload URL: %v", err)
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("failed to sign upload URL: %v", err))
+		return
+	}
+	if err := s.uploads.Create(ctx, u); err != nil {
+		logger.Errorf("failed to create upload: %v", err)
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(signedUpload{
+		ID:          u.ID,
+		JobID:       u.ID,
+		Method:      http.MethodPut,
+		UploadURL:   uploadURL,
+		ContentType: contentType,
+		ExpiresAt:   time.Now().Add(signedUploadExpiry).UTC(),
+		FinalizeURL: "/api/uploads/" + u.ID + "/finalize",
+	})
+}
+
+// handleSignedBlob serves the signed URLs of a fileblob bucket, which has no
+// server of its own. Only uploads are signed, so only PUT is served.
+func (s *apiServer) handleSignedBlob(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.WithField("path", r.URL.Path)
+	logger.Infof("request received")
+	if s.signer == nil {
+		s.sendError(w, http.StatusNotFound, "the bucket does not serve signed URLs here")
+		return
+	}
+	ctx := r.Context()
+	key, err := s.signer.KeyFromURL(ctx, r.URL)
+	if err != nil {
+		s.sendError(w, http.StatusForbidden, "invalid or expired signed URL")
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("method") != r.Method {
+		s.sendError(w, http.StatusForbidden, fmt.Sprintf("URL is signed for %s", q.Get("method")))
+		return
+	}
+	if ct := q.Get("contentType"); ct != "" && r.Header.Get("Content-Type") != ct {
+		s.sendError(w, http.StatusForbidden, fmt.Sprintf("URL is signed for content type %s", ct))
+		return
+	}
+	if r.Method != http.MethodPut {
+		s.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	// Only direct uploads are signed, for the key of their job, whose file
+	// must not be replaced once finalized.
+	u, err := s.uploads.Get(ctx, key)
+	if errors.Is(err, upload.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !u.Direct {
+		s.sendError(w, http.StatusConflict, "upload is not a direct upload")
+		return
+	}
+	if u.State != upload.StateUploading {
+		s.sendError(w, http.StatusConflict, fmt.Sprintf("upload is %s", u.State))
+		return
+	}
+
+	var tooLarge func() bool
+	if s.limits.MaxFileSize > 0 {
+		if r.ContentLength > s.limits.MaxFileSize {
+			s.sendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is %d bytes, the limit is %d", r.ContentLength, s.limits.MaxFileSize))
+			return
+		}
+		tooLarge = limitBody(w, r, s.limits.MaxFileSize)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bw, err := s.bucket.NewWriter(ctx, key, &blob.WriterOptions{ContentType: r.Header.Get("Content-Type")})
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("failed to save file: %v", err))
+		return
+	}
+	if _, err := io.Copy(bw, r.Body); err != nil {
+		// Cancelling the context before Close discards the partial file.
+		cancel()
+		bw.Close()
+		if tooLarge != nil && tooLarge() {
+			s.sendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than the limit of %d bytes", s.limits.MaxFileSize))
+			return
+		}
+		errorMsg := fmt.Sprintf("failed to save file: %v", err)
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusInternalServerError, errorMsg)
+		return
+	}
+	if err := bw.Close(); err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("failed to save file: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/apex/log"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/docstore"
+)
+
+func TestSignedBlob(t *testing.T) {
+	ctx := context.Background()
+	coll, err := docstore.OpenCollection(ctx, "mem://uploads/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	uploads := upload.NewStore(coll)
+	for _, u := range []*upload.Upload{
+		{ID: "direct", Direct: true, State: upload.StateUploading},
+		{ID: "finalized", Direct: true, State: upload.StateCompleted},
+		{ID: "chunked", State: upload.StateUploading},
+	} {
+		if err := uploads.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base, _ := url.Parse("http://localhost/signed")
+	signer := fileblob.NewURLSignerHMAC(base, []byte("secret"))
+
+	tests := []struct {
+		name string
+		key  string
+		code int
+	}{
+		{"direct upload", "direct", http.StatusOK},
+		{"unknown upload", "missing", http.StatusNotFound},
+		{"finalized upload", "finalized", http.StatusConflict},
+		// Chunked uploads compose their file on finalize.
+		{"chunked upload", "chunked", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := memblob.OpenBucket(nil)
+			defer bucket.Close()
+			s := &apiServer{logger: log.WithField("module", "api"), uploads: uploads, bucket: bucket, signer: signer}
+			u, err := signer.URLFromKey(ctx, tt.key, &driver.SignedURLOptions{Method: http.MethodPut})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPut, u.String(), strings.NewReader("a,b\n1,2\n"))
+			w := httptest.NewRecorder()
+			s.handleSignedBlob(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body.String())
+			}
+			written, err := bucket.Exists(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != (tt.code == http.StatusOK) {
+				t.Fatalf("file written %v with status %d", written, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// streamFlushInterval is how many documents are written between flushes.
+const streamFlushInterval = 100
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// nextCursorTrailer carries the cursor of the next page in NDJSON responses,
+// where every line of the body is a document.
+const nextCursorTrailer = "Next-Cursor"
+
+// docStream writes documents to the response as they are read, either as
+// the {"list": [...]} JSON object or as newline delimited JSON. Nothing is
+// written until the first document, so errors found before it can still be
+// sent with an error status.
+type docStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	enc     *json.Encoder
+	ndjson  bool
+	started bool
+	count   int
+}
+
+// newDocStream picks NDJSON when asked for with ?format=ndjson or the Accept
+// header, and JSON otherwise.
+func newDocStream(w http.ResponseWriter, r *http.Request) *docStream {
+	ndjson := r.URL.Query().Get("format") == "ndjson" ||
+		strings.Contains(r.Header.Get("Accept"), contentTypeNDJSON)
+	flusher, _ := w.(http.Flusher)
+	return &docStream{
+		w:       w,
+		flusher: flusher,
+		enc:     json.NewEncoder(w),
+		ndjson:  ndjson,
+	}
+}
+
+func (s *docStream) start() error {
+	s.started = true
+	if s.ndjson {
+		s.w.Header().Set("Content-Type", contentTypeNDJSON)
+		s.w.Header().Set("Trailer", nextCursorTrailer)
+		s.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	s.w.Header().Set("Content-Type", contentTypeJSON)
+	s.w.WriteHeader(http.StatusOK)
+	_, err := s.w.Write([]byte(`{"list":[`))
+	return err
+}
+
+func (s *docStream) write(doc map[string]interface{}) error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if !s.ndjson && s.count > 0 {
+		if _, err := s.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	if err := s.enc.Encode(doc); err != nil {
+		return err
+	}
+	s.count++
+	if s.count%streamFlushInterval == 0 {
+		s.flush()
+	}
+	return nil
+}
+
+// close ends the response, with the cursor of the next page if not empty.
+func (s *docStream) close(nextCursor string) error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if s.ndjson {
+		if nextCursor != "" {
+			s.w.Header().Set(nextCursorTrailer, nextCursor)
+		}
+		s.flush()
+		return nil
+	}
+	tail := "]"
+	if nextCursor != "" {
+		b, _ := json.Marshal(nextCursor)
+		tail += `,"nextCursor":` + string(b)
+	}
+	if _, err := s.w.Write([]byte(tail + "}\n")); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+func (s *docStream) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
@@ -1,18 +1,38 @@
 package api
 
 import (
-	"encoding/csv"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
 	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"github.com/alvarowolfx/cloud-native-go/validate"
+	"github.com/apex/log"
 	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
+	"gocloud.dev/blob"
 	"gocloud.dev/pubsub"
 )
 
+// Values of the onDuplicate form field, telling what to do when the same
+// content was already uploaded.
+const (
+	// duplicateReuse answers with the job of the earlier upload.
+	duplicateReuse = "reuse"
+	// duplicateReject fails the upload with a conflict.
+	duplicateReject = "reject"
+)
+
 func (s *apiServer) handleDocsUpload(w http.ResponseWriter, r *http.Request) {
 	tracer := otel.Tracer("api")
 	ctx := r.Context()
@@ -24,7 +44,19 @@ func (s *apiServer) handleDocsUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The whole body is read into memory or a temporary file when parsing
+	// the form, so its size is capped before.
+	var tooLarge func() bool
+	if s.limits.MaxFileSize > 0 {
+		tooLarge = limitBody(w, r, s.limits.MaxFileSize+multipartOverhead)
+	}
 	file, handler, err := r.FormFile("file")
+	if err != nil && tooLarge != nil && tooLarge() {
+		errorMsg := fmt.Sprintf("request is larger than the file size limit of %d bytes", s.limits.MaxFileSize)
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusRequestEntityTooLarge, errorMsg)
+		return
+	}
 	if err != nil {
 		errorMsg := fmt.Sprintf("missing file: %v", err)
 		logger.Error(errorMsg)
@@ -34,11 +66,55 @@ func (s *apiServer) handleDocsUpload(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 	logger.WithField("size", handler.Size).WithField("filename", handler.Filename).Infof("file received")
 
-	ctx, spanParse := tracer.Start(ctx, "csv.parse")
+	def, err := s.uploadSchema(ctx, r.Form)
+	if err != nil {
+		errorMsg := err.Error()
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusBadRequest, errorMsg)
+		return
+	}
+
+	opts, err := uploadParseOptions(r.Form, handler.Header.Get("Content-Type"), handler.Filename)
+	if err != nil {
+		errorMsg := err.Error()
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusBadRequest, errorMsg)
+		return
+	}
+
+	scan, err := s.uploadScan(r.FormValue("scan"))
+	if err != nil {
+		errorMsg := err.Error()
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusBadRequest, errorMsg)
+		return
+	}
+
+	expiresAt, err := uploadExpiry(r.FormValue("ttl"))
+	if err != nil {
+		errorMsg := err.Error()
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusBadRequest, errorMsg)
+		return
+	}
+
+	onDuplicate, err := uploadOnDuplicate(r.FormValue("onDuplicate"))
+	if err != nil {
+		errorMsg := err.Error()
+		logger.Error(errorMsg)
+		s.sendError(w, http.StatusBadRequest, errorMsg)
+		return
+	}
+
+	ctx, spanParse := tracer.Start(ctx, "file.parse")
 	defer spanParse.End()
-	csvReader := csv.NewReader(file)
-	csvReader.LazyQuotes = true
-	_, err = csvReader.Read()
+	err = validate.File(file, handler.Size, &opts, s.limits, scan)
+	var ve *validate.Error
+	if errors.As(err, &ve) {
+		logger.Error(ve.Error())
+		s.sendValidationReport(w, ve.Report)
+		return
+	}
 	if err != nil {
 		errorMsg := fmt.Sprintf("failed to parse file: %v", err)
 		logger.Error(errorMsg)
@@ -47,18 +123,32 @@ func (s *apiServer) handleDocsUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	spanParse.End()
 
-	_, err = file.Seek(0, io.SeekStart)
+	// The multipart file is already held locally, so it is hashed before
+	// being written: blob metadata is fixed when the write starts, and
+	// resent content is not written at all.
+	sum, err := hashFile(file)
 	if err != nil {
 		errorMsg := fmt.Sprintf("failed to read file: %v", err)
 		logger.Error(errorMsg)
 		s.sendError(w, http.StatusInternalServerError, errorMsg)
 		return
 	}
+	existing, err := s.findDuplicate(ctx, logger, requestOwner(r), sum, onDuplicate)
+	if err != nil {
+		s.sendDuplicateError(w, logger, err)
+		return
+	}
+	if existing != nil {
+		s.sendDuplicate(w, existing)
+		return
+	}
 
 	ctx, spanUpload := tracer.Start(ctx, "file.upload")
 	defer spanUpload.End()
 	jobId := uuid.NewString()
-	writer, err := s.bucket.NewWriter(ctx, jobId, nil)
+	writer, err := s.bucket.NewWriter(ctx, jobId, &blob.WriterOptions{
+		Metadata: map[string]string{"sha256": sum},
+	})
 	if err != nil {
 		errorMsg := fmt.Sprintf("failed to save file: %v", err)
 		logger.Error(errorMsg)
@@ -76,19 +166,24 @@ func (s *apiServer) handleDocsUpload(w http.ResponseWriter, r *http.Request) {
 	writer.Close()
 	spanUpload.End()
 
-	s.totalFileUploaded.Add(ctx, 1)
-	s.totalFileSizeUploaded.Add(ctx, totalRead)
+	uploader := uploadUploader(r, r.FormValue("uploader"))
+	j := &job.Job{
+		ID:       jobId,
+		Filename: handler.Filename,
+		Size:     totalRead,
+		SHA256:   sum,
+		Uploader: uploader,
+		Owner:    requestOwner(r),
+		State:    job.StateUploaded,
 
-	msg := &pubsub.Message{
-		Body: []byte(jobId),
-		Metadata: map[string]string{
-			"eventType": "file.upload",
-		},
+		ExpiresAt: expiresAt,
+
+		SchemaDefinition: def,
+		Parse:            opts,
 	}
-	otel.GetTextMapPropagator().Inject(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
-	err = s.topic.Send(ctx, msg)
+	j, err = s.submitJob(ctx, j)
 	if err != nil {
-		errorMsg := fmt.Sprintf("failed to queue file to be processed: %v", err)
+		errorMsg := err.Error()
 		logger.Error(errorMsg)
 		s.sendError(w, http.StatusInternalServerError, errorMsg)
 		return
@@ -100,5 +195,193 @@ func (s *apiServer) handleDocsUpload(w http.ResponseWriter, r *http.Request) {
 		"id":        jobId,
 		"totalRead": fmt.Sprintf("%v", totalRead),
 		"size":      fmt.Sprintf("%v", handler.Size),
+		"sha256":    sum,
+		"state":     string(j.State),
 	})
 }
+
+// uploadOnDuplicate checks the onDuplicate form field, reusing the earlier
+// job when empty.
+func uploadOnDuplicate(onDuplicate string) (string, error) {
+	switch onDuplicate {
+	case "":
+		return duplicateReuse, nil
+	case duplicateReuse, duplicateReject:
+		return onDuplicate, nil
+	}
+	return "", fmt.Errorf("invalid onDuplicate %q, expected %s or %s", onDuplicate, duplicateReuse, duplicateReject)
+}
+
+// duplicateError rejects content already uploaded as another job.
+type duplicateError struct {
+	jobId string
+}
+
+func (e *duplicateError) Error() string {
+	return fmt.Sprintf("file was already uploaded as job %s", e.jobId)
+}
+
+// findDuplicate returns the job owner already uploaded the content hashed to
+// sum as, or nil when there is none. It fails with a *duplicateError when
+// onDuplicate rejects such content.
+func (s *apiServer) findDuplicate(ctx context.Context, logger *log.Entry, owner, sum, onDuplicate string) (*job.Job, error) {
+	existing, err := s.jobs.FindByHash(ctx, owner, sum)
+	if errors.Is(err, job.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("jobId", existing.ID).WithField("sha256", sum).Infof("duplicate upload")
+	if onDuplicate == duplicateReject {
+		return nil, &duplicateError{jobId: existing.ID}
+	}
+	return existing, nil
+}
+
+func (s *apiServer) sendDuplicateError(w http.ResponseWriter, logger *log.Entry, err error) {
+	var de *duplicateError
+	if errors.As(err, &de) {
+		s.sendError(w, http.StatusConflict, de.Error())
+		return
+	}
+	logger.Error(err.Error())
+	s.sendError(w, http.StatusInternalServerError, err.Error())
+}
+
+// sendDuplicate answers with the earlier job of reused content.
+func (s *apiServer) sendDuplicate(w http.ResponseWriter, existing *job.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"id":        existing.ID,
+		"size":      fmt.Sprintf("%v", existing.Size),
+		"sha256":    existing.SHA256,
+		"state":     string(existing.State),
+		"duplicate": "true",
+	})
+}
+
+// multipartOverhead is the room left in a multipart body for the form
+// fields and boundaries around the file.
+const multipartOverhead = 1 << 20
+
+// limitBody makes reading the body of r fail past limit bytes, as
+// http.MaxBytesReader does. The returned func tells whether it did, which
+// the error seen through a multipart reader does not.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) func() bool {
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = http.MaxBytesReader(w, body, limit)
+	return func() bool {
+		return r.ContentLength > limit || body.n > limit
+	}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// hashFile returns the hex SHA-256 of the content of f, leaving it rewound.
+func hashFile(f io.ReadSeeker) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// submitJob registers an uploaded file as a job and queues it for the
+// worker.
+func (s *apiServer) submitJob(ctx context.Context, j *job.Job) (*job.Job, error) {
+	if err := s.jobs.Create(ctx, j); err != nil {
+		return nil, fmt.Errorf("failed to register job: %v", err)
+	}
+
+	s.totalFileUploaded.Add(ctx, 1)
+	s.totalFileSizeUploaded.Add(ctx, j.Size)
+
+	j, err := s.jobs.Transition(ctx, j.ID, job.StateQueued, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to update job: %v", err)
+	}
+	if err := s.publishJob(ctx, j.ID); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// publishJob asks the worker to ingest a queued job, failing the job when the
+// message cannot be sent.
+func (s *apiServer) publishJob(ctx context.Context, jobId string) error {
+	msg := &pubsub.Message{
+		Body: []byte(jobId),
+		Metadata: map[string]string{
+			"eventType": "file.upload",
+		},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
+	if err := s.topic.Send(ctx, msg); err != nil {
+		errorMsg := fmt.Sprintf("failed to queue file to be processed: %v", err)
+		if _, jerr := s.jobs.Transition(ctx, jobId, job.StateFailed, errorMsg); jerr != nil {
+			s.logger.Errorf("failed to update job: %v", jerr)
+		}
+		return errors.New(errorMsg)
+	}
+	return nil
+}
+
+// uploadParseOptions picks the format of the uploaded file from the format
+// form field, its content type or its name, with the delimiter form field
+// for CSV files and the sheet form field for workbooks.
+func uploadParseOptions(form url.Values, contentType, filename string) (parser.Options, error) {
+	format, err := parser.Detect(form.Get("format"), contentType, filename)
+	if err != nil {
+		return parser.Options{}, err
+	}
+	opts := parser.Options{
+		Format:    format,
+		Delimiter: form.Get("delimiter"),
+		Sheet:     form.Get("sheet"),
+	}
+	return opts, opts.Validate()
+}
+
+// uploadUploader returns the uploader named by an upload, or the address it
+// came from without its port, which changes with every connection.
+func uploadUploader(r *http.Request, uploader string) string {
+	if uploader != "" {
+		return uploader
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// uploadExpiry turns the ttl field of an upload, a duration such as 720h,
+// into when the data of its job is deleted. Without it the job is only
+// deleted by the retention limits of the worker.
+func uploadExpiry(ttl string) (time.Time, error) {
+	if ttl == "" {
+		return time.Time{}, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid ttl %q, expected a duration such as 720h", ttl)
+	}
+	return time.Now().UTC().Add(d), nil
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/alvarowolfx/cloud-native-go/validate"
+)
+
+// uploadScan tells whether an upload asked for every row to be checked with
+// the scan field, defaulting to the server setting.
+func (s *apiServer) uploadScan(value string) (bool, error) {
+	if value == "" {
+		return s.limits.Scan, nil
+	}
+	scan, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid scan %q, expected true or false", value)
+	}
+	return scan, nil
+}
+
+func (s *apiServer) sendValidationReport(w http.ResponseWriter, report *validate.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(report)
+}
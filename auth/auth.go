@@ -0,0 +1,206 @@
+// Package auth authenticates API requests with keys. Keys are only stored as
+// their SHA-256, so the collection holding them does not give them away.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+var (
+	ErrNotFound = errors.New("api key not found")
+	// ErrInvalidKey is returned for keys that are unknown or revoked, which
+	// are not told apart.
+	ErrInvalidKey = errors.New("invalid api key")
+)
+
+type Scope string
+
+const (
+	// ScopeUser keys only reach the jobs they uploaded and their documents.
+	ScopeUser Scope = "user"
+	// ScopeAdmin keys reach every job and manage keys.
+	ScopeAdmin Scope = "admin"
+)
+
+// keyPrefix starts every key, so they are easy to spot when leaked.
+const keyPrefix = "cng_"
+
+// Key is an API key. ID identifies it on the jobs it owns, Hash is the
+// SHA-256 of the key itself, which is only shown when created.
+type Key struct {
+	Hash      string    `docstore:"hash" json:"-"`
+	ID        string    `docstore:"id" json:"id"`
+	Name      string    `docstore:"name" json:"name"`
+	Scope     Scope     `docstore:"scope" json:"scope"`
+	CreatedAt time.Time `docstore:"createdAt" json:"createdAt"`
+	RevokedAt time.Time `docstore:"revokedAt" json:"revokedAt,omitempty"`
+
+	DocstoreRevision interface{} `json:"-"`
+}
+
+func (k *Key) Admin() bool {
+	return k.Scope == ScopeAdmin
+}
+
+// Owns tells whether the key may reach data tagged with owner.
+func (k *Key) Owns(owner string) bool {
+	return k.Admin() || owner == k.ID
+}
+
+// Hash returns the hex SHA-256 a key is stored under.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScope checks s names a scope, defaulting to ScopeUser.
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case "", ScopeUser:
+		return ScopeUser, nil
+	case ScopeAdmin:
+		return ScopeAdmin, nil
+	}
+	return "", fmt.Errorf("unknown scope %q, use one of: user, admin", s)
+}
+
+type keyContextKey struct{}
+
+// NewContext returns a context carrying the key of the caller.
+func NewContext(ctx context.Context, k *Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, k)
+}
+
+// FromContext returns the key of the caller, nil for unauthenticated
+// requests.
+func FromContext(ctx context.Context) *Key {
+	k, _ := ctx.Value(keyContextKey{}).(*Key)
+	return k
+}
+
+type Store struct {
+	coll *docstore.Collection
+}
+
+// NewStore keeps keys in coll, which must be keyed by hash.
+func NewStore(coll *docstore.Collection) *Store {
+	return &Store{coll: coll}
+}
+
+func (s *Store) Close() error {
+	return s.coll.Close()
+}
+
+// Create generates a key with the given name and scope and returns it along
+// with its record. The key cannot be recovered afterwards.
+func (s *Store) Create(ctx context.Context, name string, scope Scope) (string, *Key, error) {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate api key: %v", err)
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	k := &Key{
+		Hash:  Hash(key),
+		ID:    uuid.NewString(),
+		Name:  name,
+		Scope: scope,
+	}
+	if err := s.put(ctx, k); err != nil {
+		return "", nil, err
+	}
+	return key, k, nil
+}
+
+// Ensure registers a key chosen outside of the service, as the admin key
+// given to the API on start, unless it already is.
+func (s *Store) Ensure(ctx context.Context, key, name string, scope Scope) (*Key, error) {
+	k := &Key{Hash: Hash(key)}
+	err := s.coll.Get(ctx, k)
+	if err == nil {
+		return k, nil
+	}
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, fmt.Errorf("failed to get api key: %v", err)
+	}
+	k = &Key{Hash: k.Hash, ID: uuid.NewString(), Name: name, Scope: scope}
+	if err := s.put(ctx, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s *Store) put(ctx context.Context, k *Key) error {
+	k.CreatedAt = time.Now().UTC()
+	if err := s.coll.Create(ctx, k); err != nil {
+		return fmt.Errorf("failed to create api key: %v", err)
+	}
+	return nil
+}
+
+// Authenticate returns the record of key, or ErrInvalidKey.
+func (s *Store) Authenticate(ctx context.Context, key string) (*Key, error) {
+	k := &Key{Hash: Hash(key)}
+	if err := s.coll.Get(ctx, k); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ErrInvalidKey
+		}
+		return nil, fmt.Errorf("failed to get api key: %v", err)
+	}
+	if !k.RevokedAt.IsZero() {
+		return nil, ErrInvalidKey
+	}
+	return k, nil
+}
+
+// List returns every key, revoked ones included.
+func (s *Store) List(ctx context.Context) ([]*Key, error) {
+	iter := s.coll.Query().Get(ctx)
+	defer iter.Stop()
+	var keys []*Key
+	for {
+		k := &Key{}
+		err := iter.Next(ctx, k)
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list api keys: %v", err)
+		}
+		keys = append(keys, k)
+	}
+}
+
+// Revoke stops the key of the given id from authenticating. Its record is
+// kept, as jobs are still tagged with its id.
+func (s *Store) Revoke(ctx context.Context, id string) (*Key, error) {
+	iter := s.coll.Query().Where("id", "=", id).Get(ctx)
+	defer iter.Stop()
+	k := &Key{}
+	err := iter.Next(ctx, k)
+	if err == io.EOF {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api key: %v", err)
+	}
+	if !k.RevokedAt.IsZero() {
+		return k, nil
+	}
+	k.RevokedAt = time.Now().UTC()
+	if err := s.coll.Replace(ctx, k); err != nil {
+		return nil, fmt.Errorf("failed to revoke api key: %v", err)
+	}
+	return k, nil
+}
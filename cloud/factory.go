@@ -3,6 +3,7 @@ package cloud
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"strings"
@@ -16,7 +17,7 @@ import (
 	"gocloud.dev/pubsub"
 
 	// Import providers for blob storage
-	_ "gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/fileblob"
 	_ "gocloud.dev/blob/gcsblob"
 	_ "gocloud.dev/blob/s3blob"
 
@@ -83,11 +84,54 @@ func NewBucket(prefix string) (*blob.Bucket, error) {
 	return bucket, nil
 }
 
+// NewURLSigner returns the signer of a fileblob bucket opened with the
+// base_url and secret_key_path parameters, to verify the signed URLs it hands
+// out. Other buckets have their provider serve signed URLs and get nil.
+func NewURLSigner() (fileblob.URLSigner, error) {
+	u, err := url.Parse(os.Getenv("BUCKET_URL"))
+	if err != nil || u.Scheme != fileblob.Scheme {
+		return nil, nil
+	}
+	q := u.Query()
+	if q.Get("base_url") == "" || q.Get("secret_key_path") == "" {
+		return nil, nil
+	}
+	base, err := url.Parse(q.Get("base_url"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base_url: %v", err)
+	}
+	key, err := ioutil.ReadFile(q.Get("secret_key_path"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret key: %v", err)
+	}
+	return fileblob.NewURLSignerHMAC(base, key), nil
+}
+
 func NewTopic() (*pubsub.Topic, error) {
+	return openTopic("PUBSUB_TOPIC_URL", "mem://events")
+}
+
+func NewTopicSub() (*pubsub.Subscription, error) {
+	return openSubscription("PUBSUB_SUB_URL", "mem://events")
+}
+
+func NewDeadLetterTopic() (*pubsub.Topic, error) {
+	return openTopic("PUBSUB_DEADLETTER_TOPIC_URL", "mem://events-deadletter")
+}
+
+func NewProgressTopic() (*pubsub.Topic, error) {
+	return openTopic("PUBSUB_PROGRESS_TOPIC_URL", "mem://progress")
+}
+
+func NewProgressSub() (*pubsub.Subscription, error) {
+	return openSubscription("PUBSUB_PROGRESS_SUB_URL", "mem://progress")
+}
+
+func openTopic(env, fallback string) (*pubsub.Topic, error) {
 	ctx := context.Background()
-	url := os.Getenv("PUBSUB_TOPIC_URL")
+	url := os.Getenv(env)
 	if url == "" {
-		url = "mem://events"
+		url = fallback
 	}
 	t, err := pubsub.OpenTopic(ctx, url)
 	if err != nil {
@@ -96,11 +140,17 @@ func NewTopic() (*pubsub.Topic, error) {
 	return t, nil
 }
 
-func NewTopicSub() (*pubsub.Subscription, error) {
+func openSubscription(env, fallback string) (*pubsub.Subscription, error) {
 	ctx := context.Background()
-	url := os.Getenv("PUBSUB_SUB_URL")
+	url := os.Getenv(env)
 	if url == "" {
-		url = "mem://events"
+		url = fallback
+	}
+	if strings.HasPrefix(url, "mem://") {
+		// In-memory subscriptions need their topic to exist in this process.
+		if _, err := pubsub.OpenTopic(ctx, strings.SplitN(url, "?", 2)[0]); err != nil {
+			return nil, fmt.Errorf("could not open topic: %v", err)
+		}
 	}
 	sub, err := pubsub.OpenSubscription(ctx, url)
 	if err != nil {
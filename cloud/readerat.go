@@ -0,0 +1,79 @@
+package cloud
+
+import (
+	"context"
+	"io"
+
+	"gocloud.dev/blob"
+)
+
+// readAhead is how much of a blob is fetched at once, the readers needing
+// random access, like the zip one, asking for a few kilobytes at a time.
+const readAhead = 1024 * 1024
+
+// BlobReaderAt gives random access over a blob through range reads, for
+// files like zip archives that cannot be read as a stream.
+type BlobReaderAt struct {
+	ctx    context.Context
+	bucket *blob.Bucket
+	key    string
+	size   int64
+	buf    []byte
+	off    int64
+}
+
+// NewBlobReaderAt looks up the size of the blob at key. Reads use ctx, as
+// io.ReaderAt takes none.
+func NewBlobReaderAt(ctx context.Context, bucket *blob.Bucket, key string) (*BlobReaderAt, error) {
+	attrs, err := bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &BlobReaderAt{ctx: ctx, bucket: bucket, key: key, size: attrs.Size}, nil
+}
+
+func (ra *BlobReaderAt) Size() int64 {
+	return ra.size
+}
+
+func (ra *BlobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= ra.size {
+			return n, io.EOF
+		}
+		if pos < ra.off || pos >= ra.off+int64(len(ra.buf)) {
+			if err := ra.fill(pos, len(p)-n); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], ra.buf[pos-ra.off:])
+	}
+	return n, nil
+}
+
+func (ra *BlobReaderAt) fill(off int64, min int) error {
+	length := int64(readAhead)
+	if int64(min) > length {
+		length = int64(min)
+	}
+	if off+length > ra.size {
+		length = ra.size - off
+	}
+	r, err := ra.bucket.NewRangeReader(ra.ctx, ra.key, off, length, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if int64(cap(ra.buf)) < length {
+		ra.buf = make([]byte, length)
+	}
+	ra.buf = ra.buf[:length]
+	if _, err := io.ReadFull(r, ra.buf); err != nil {
+		ra.buf = ra.buf[:0]
+		return err
+	}
+	ra.off = off
+	return nil
+}
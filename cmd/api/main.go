@@ -5,10 +5,18 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/alvarowolfx/cloud-native-go/api"
+	"github.com/alvarowolfx/cloud-native-go/auth"
 	"github.com/alvarowolfx/cloud-native-go/cloud"
+	"github.com/alvarowolfx/cloud-native-go/env"
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/schema"
 	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/alvarowolfx/cloud-native-go/validate"
 	"github.com/apex/log"
 	"github.com/joho/godotenv"
 )
@@ -26,6 +34,17 @@ func main() {
 		port = "9090"
 	}
 
+	shutdownTimeout := env.Duration("SHUTDOWN_TIMEOUT", 30*time.Second)
+
+	// Limits left unset are not enforced.
+	limits := validate.Limits{
+		MaxFileSize:  env.Int64OrZero("UPLOAD_MAX_FILE_SIZE", 0),
+		MaxRows:      env.IntOrZero("UPLOAD_MAX_ROWS", 0),
+		MaxColumns:   env.IntOrZero("UPLOAD_MAX_COLUMNS", 0),
+		HeaderChecks: env.Bool("UPLOAD_HEADER_CHECKS", true),
+		Scan:         env.Bool("UPLOAD_SCAN", false),
+	}
+
 	sigs := make(chan os.Signal, 1)
 	errs := make(chan error, 1)
 	done := make(chan bool, 1)
@@ -34,20 +53,68 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to open bucket: %v", err)
 	}
-	defer bucket.Close()
+
+	signer, err := cloud.NewURLSigner()
+	if err != nil {
+		log.Fatalf("failed to open bucket url signer: %v", err)
+	}
 
 	coll, err := cloud.NewDocstore("docs", "id")
 	if err != nil {
 		log.Fatalf("failed to open docstore: %v", err)
 	}
 
+	quarantine, err := cloud.NewDocstore("quarantine", "id")
+	if err != nil {
+		log.Fatalf("failed to open quarantine docstore: %v", err)
+	}
+
+	schemasColl, err := cloud.NewDocstore("schemas", "name")
+	if err != nil {
+		log.Fatalf("failed to open schemas docstore: %v", err)
+	}
+
+	jobsColl, err := cloud.NewDocstore("jobs", "id")
+	if err != nil {
+		log.Fatalf("failed to open jobs docstore: %v", err)
+	}
+
+	exportsColl, err := cloud.NewDocstore("exports", "id")
+	if err != nil {
+		log.Fatalf("failed to open exports docstore: %v", err)
+	}
+
+	uploadsColl, err := cloud.NewDocstore("uploads", "id")
+	if err != nil {
+		log.Fatalf("failed to open uploads docstore: %v", err)
+	}
+
+	keysColl, err := cloud.NewDocstore("apikeys", "hash")
+	if err != nil {
+		log.Fatalf("failed to open api keys docstore: %v", err)
+	}
+	keys := auth.NewStore(keysColl)
+	// The admin key is registered on start, other keys are created with it
+	// through the API.
+	if v := os.Getenv("ADMIN_API_KEY"); v != "" {
+		if _, err := keys.Ensure(context.Background(), v, "admin", auth.ScopeAdmin); err != nil {
+			log.Fatalf("failed to register admin api key: %v", err)
+		}
+	} else {
+		log.Warn("ADMIN_API_KEY is not set, only existing api keys can be used")
+	}
+
 	topic, err := cloud.NewTopic()
 	if err != nil {
 		log.Fatalf("failed to open pubsub topic: %v", err)
 	}
-	defer topic.Shutdown(context.Background())
 
-	srv := api.NewServer(coll, topic, bucket, port, errs)
+	progressSub, err := cloud.NewProgressSub()
+	if err != nil {
+		log.Fatalf("failed to open progress subscription: %v", err)
+	}
+
+	srv := api.NewServer(coll, quarantine, job.NewStore(jobsColl), schema.NewRegistry(schemasColl), export.NewStore(exportsColl), upload.NewStore(uploadsColl), keys, topic, progressSub, bucket, signer, limits, port, errs)
 	go srv.Start()
 
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -64,5 +131,15 @@ func main() {
 
 	log.Info("waiting shutdown")
 	<-done
+	log.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Errorf("failed to shutdown server: %v", err)
+	}
+	if err := telemetry.Shutdown(ctx); err != nil {
+		log.Errorf("failed to shutdown telemetry: %v", err)
+	}
 	log.Info("shutdown")
 }
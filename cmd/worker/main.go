@@ -5,9 +5,15 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/alvarowolfx/cloud-native-go/cloud"
+	"github.com/alvarowolfx/cloud-native-go/env"
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
 	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/alvarowolfx/cloud-native-go/validate"
 	"github.com/alvarowolfx/cloud-native-go/worker"
 	"github.com/apex/log"
 	"github.com/joho/godotenv"
@@ -29,32 +35,85 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to open bucket: %v", err)
 	}
-	defer bucket.Close()
 
 	coll, err := cloud.NewDocstore("docs", "id")
 	if err != nil {
 		log.Fatalf("failed to open collection: %v", err)
 	}
-	defer coll.Close()
+
+	quarantine, err := cloud.NewDocstore("quarantine", "id")
+	if err != nil {
+		log.Fatalf("failed to open quarantine collection: %v", err)
+	}
+
+	jobsColl, err := cloud.NewDocstore("jobs", "id")
+	if err != nil {
+		log.Fatalf("failed to open jobs collection: %v", err)
+	}
+
+	exportsColl, err := cloud.NewDocstore("exports", "id")
+	if err != nil {
+		log.Fatalf("failed to open exports collection: %v", err)
+	}
+
+	uploadsColl, err := cloud.NewDocstore("uploads", "id")
+	if err != nil {
+		log.Fatalf("failed to open uploads collection: %v", err)
+	}
 
 	sub, err := cloud.NewTopicSub()
 	if err != nil {
 		log.Fatalf("failed to open pubsub topic: %v", err)
 	}
-	defer func() {
-		err := sub.Shutdown(context.Background())
-		if err != nil {
-			log.Errorf("failed to shutdown pubsub topic: %v", err)
-			errs <- err
-		}
-	}()
+
+	topic, err := cloud.NewTopic()
+	if err != nil {
+		log.Fatalf("failed to open pubsub topic: %v", err)
+	}
+
+	deadLetter, err := cloud.NewDeadLetterTopic()
+	if err != nil {
+		log.Fatalf("failed to open dead-letter topic: %v", err)
+	}
+
+	progress, err := cloud.NewProgressTopic()
+	if err != nil {
+		log.Fatalf("failed to open progress topic: %v", err)
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
 	}
 
-	w := worker.New(port, errs, coll, bucket, sub)
+	opts := worker.Options{
+		BatchSize:         env.Int("WORKER_BATCH_SIZE", 500),
+		MaxAttempts:       env.Int("WORKER_MAX_ATTEMPTS", 5),
+		RetryBackoff:      env.Duration("WORKER_RETRY_BACKOFF", time.Second),
+		MaxRetryBackoff:   env.Duration("WORKER_MAX_RETRY_BACKOFF", time.Minute),
+		Concurrency:       env.Int("WORKER_CONCURRENCY", 4),
+		MaxPendingRetries: env.Int("WORKER_MAX_PENDING_RETRIES", 100),
+
+		RetentionMaxAge:   env.DurationOrZero("RETENTION_MAX_AGE", 0),
+		RetentionKeepLast: env.IntOrZero("RETENTION_KEEP_LAST", 0),
+		RetentionInterval: env.Duration("RETENTION_INTERVAL", time.Hour),
+		// Longer than the signed upload URLs are valid.
+		UploadMaxAge: env.DurationOrZero("UPLOAD_MAX_AGE", 24*time.Hour),
+
+		// The files of archives are held to the limits of the API.
+		Limits: validate.Limits{
+			MaxFileSize:  env.Int64OrZero("UPLOAD_MAX_FILE_SIZE", 0),
+			MaxRows:      env.IntOrZero("UPLOAD_MAX_ROWS", 0),
+			MaxColumns:   env.IntOrZero("UPLOAD_MAX_COLUMNS", 0),
+			HeaderChecks: env.Bool("UPLOAD_HEADER_CHECKS", true),
+			Scan:         env.Bool("UPLOAD_SCAN", false),
+		},
+		ArchiveMaxEntries:   env.IntOrZero("ARCHIVE_MAX_ENTRIES", 1000),
+		ArchiveMaxEntrySize: env.Int64OrZero("ARCHIVE_MAX_ENTRY_SIZE", 1<<30),
+		ArchiveMaxDepth:     env.IntOrZero("ARCHIVE_MAX_DEPTH", 2),
+	}
+
+	w := worker.New(port, errs, coll, quarantine, job.NewStore(jobsColl), export.NewStore(exportsColl), upload.NewStore(uploadsColl), bucket, sub, topic, deadLetter, progress, opts)
 	go w.Start()
 
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -71,5 +130,15 @@ func main() {
 
 	log.Info("waiting shutdown")
 	<-done
+	log.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), env.Duration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		log.Errorf("failed to shutdown worker: %v", err)
+	}
+	if err := telemetry.Shutdown(ctx); err != nil {
+		log.Errorf("failed to shutdown telemetry: %v", err)
+	}
 	log.Info("shutdown")
 }
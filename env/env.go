@@ -0,0 +1,79 @@
+// Package env reads the settings of the commands from environment variables,
+// stopping the process on invalid values.
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Int returns the positive integer in key, or fallback when unset.
+func Int(key string, fallback int) int {
+	return int(Int64(key, int64(fallback)))
+}
+
+// IntOrZero is Int for settings where zero turns something off.
+func IntOrZero(key string, fallback int) int {
+	return int(Int64OrZero(key, int64(fallback)))
+}
+
+// Int64 returns the positive integer in key, or fallback when unset.
+func Int64(key string, fallback int64) int64 {
+	return parseInt64(key, fallback, 1)
+}
+
+// Int64OrZero is Int64 for settings where zero turns something off.
+func Int64OrZero(key string, fallback int64) int64 {
+	return parseInt64(key, fallback, 0)
+}
+
+func parseInt64(key string, fallback, min int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < min {
+		log.Fatalf("invalid %s: %q", key, v)
+	}
+	return n
+}
+
+// Bool returns the boolean in key, or fallback when unset.
+func Bool(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %q", key, v)
+	}
+	return b
+}
+
+// Duration returns the positive duration in key, such as 30s, or fallback
+// when unset.
+func Duration(key string, fallback time.Duration) time.Duration {
+	return parseDuration(key, fallback, 1)
+}
+
+// DurationOrZero is Duration for settings where zero turns something off.
+func DurationOrZero(key string, fallback time.Duration) time.Duration {
+	return parseDuration(key, fallback, 0)
+}
+
+func parseDuration(key string, fallback, min time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < min {
+		log.Fatalf("invalid %s: %q", key, v)
+	}
+	return d
+}
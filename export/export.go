@@ -0,0 +1,275 @@
+// Package export turns the documents of a job back into a file.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"github.com/xitongsys/parquet-go/writer"
+	"gocloud.dev/docstore"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatNDJSON  Format = "ndjson"
+	FormatParquet Format = "parquet"
+)
+
+// parquetRowGroupSize bounds how much of an export is held in memory before
+// being written out.
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatNDJSON, FormatParquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown export format %q, use one of: csv, ndjson, parquet", s)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+// Writer writes documents in the columns of a job schema. Close writes
+// anything still buffered but does not close the underlying writer.
+type Writer interface {
+	Write(doc map[string]interface{}) error
+	Close() error
+}
+
+// NewWriter returns a writer of format f. The CSV header uses the column
+// names found in the original file, NDJSON and Parquet use the names the
+// documents are stored with, like the query API.
+func NewWriter(f Format, w io.Writer, columns []schema.Column) (Writer, error) {
+	switch f {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatNDJSON:
+		return &ndjsonWriter{w: w, columns: columns}, nil
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %q", f)
+}
+
+// Filename names the export of j after the uploaded file.
+func Filename(j *job.Job, f Format) string {
+	name := strings.TrimSuffix(path.Base(j.Filename), path.Ext(j.Filename))
+	if name == "" || name == "." || name == "/" {
+		name = j.ID
+	}
+	return name + "." + string(f)
+}
+
+// Documents calls fn with every document of a job, in the order of the lines
+// of its file.
+func Documents(ctx context.Context, coll *docstore.Collection, jobId string, fn func(doc map[string]interface{}) error) error {
+	// Record ids are the job id followed by the line number, so this range
+	// holds exactly the job documents.
+	iter := coll.Query().
+		Where("id", ">", jobId+"-").
+		Where("id", "<", jobId+".").
+		OrderBy("id", docstore.Ascending).
+		Get(ctx)
+	defer iter.Stop()
+	for {
+		doc := map[string]interface{}{}
+		err := iter.Next(ctx, doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read documents: %w", err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []schema.Column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []schema.Column) (*csvWriter, error) {
+	cw := &csvWriter{
+		w:       csv.NewWriter(w),
+		columns: columns,
+		record:  make([]string, len(columns)),
+	}
+	for i, c := range columns {
+		cw.record[i] = c.Source
+		if c.Source == "" {
+			cw.record[i] = c.Name
+		}
+	}
+	return cw, cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Write(doc map[string]interface{}) error {
+	for i, c := range cw.columns {
+		cw.record[i] = formatValue(doc[c.Name])
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// ndjsonWriter writes one object per line with its keys in column order,
+// which encoding/json would sort.
+type ndjsonWriter struct {
+	w       io.Writer
+	columns []schema.Column
+	buf     []byte
+}
+
+func (nw *ndjsonWriter) Write(doc map[string]interface{}) error {
+	nw.buf = append(nw.buf[:0], '{')
+	for i, c := range nw.columns {
+		if i > 0 {
+			nw.buf = append(nw.buf, ',')
+		}
+		k, _ := json.Marshal(c.Name)
+		v, err := json.Marshal(doc[c.Name])
+		if err != nil {
+			return err
+		}
+		nw.buf = append(nw.buf, k...)
+		nw.buf = append(nw.buf, ':')
+		nw.buf = append(nw.buf, v...)
+	}
+	nw.buf = append(nw.buf, '}', '\n')
+	_, err := nw.w.Write(nw.buf)
+	return err
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	pw      *writer.CSVWriter
+	columns []schema.Column
+}
+
+func newParquetWriter(w io.Writer, columns []schema.Column) (*parquetWriter, error) {
+	md := make([]string, len(columns))
+	for i, c := range columns {
+		md[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", parquetName(c.Name), parquetType(c.Type))
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parquet writer: %v", err)
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	return &parquetWriter{pw: pw, columns: columns}, nil
+}
+
+func parquetType(t schema.Type) string {
+	switch t {
+	case schema.TypeBool:
+		return "type=BOOLEAN"
+	case schema.TypeInt:
+		return "type=INT64"
+	case schema.TypeFloat:
+		return "type=DOUBLE"
+	case schema.TypeTimestamp:
+		return "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+	}
+	return "type=BYTE_ARRAY, convertedtype=UTF8"
+}
+
+// parquetName drops the characters the parquet schema metadata uses as
+// separators.
+func parquetName(name string) string {
+	return strings.NewReplacer(",", "_", "=", "_").Replace(name)
+}
+
+func (pw *parquetWriter) Write(doc map[string]interface{}) error {
+	rec := make([]interface{}, len(pw.columns))
+	for i, c := range pw.columns {
+		rec[i] = parquetValue(c.Type, doc[c.Name])
+	}
+	return pw.pw.Write(rec)
+}
+
+// parquetValue converts v to the type of its parquet column, leaving values
+// that do not fit it out.
+func parquetValue(t schema.Type, v interface{}) interface{} {
+	switch t {
+	case schema.TypeBool:
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	case schema.TypeInt:
+		switch n := v.(type) {
+		case int64:
+			return n
+		case int32:
+			return int64(n)
+		case int:
+			return int64(n)
+		}
+	case schema.TypeFloat:
+		switch n := v.(type) {
+		case float64:
+			return n
+		case int64:
+			return float64(n)
+		}
+	case schema.TypeTimestamp:
+		if ts, ok := v.(time.Time); ok {
+			return ts.UnixNano() / int64(time.Millisecond)
+		}
+	default:
+		if v != nil {
+			return formatValue(v)
+		}
+	}
+	return nil
+}
+
+func (pw *parquetWriter) Close() error {
+	return pw.pw.WriteStop()
+}
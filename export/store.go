@@ -0,0 +1,157 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+// EventRequested is the eventType of the messages asking the worker to write
+// an export.
+const EventRequested = "export.requested"
+
+var ErrNotFound = errors.New("export not found")
+
+// Export is a file written to the bucket from the documents of a job. It
+// goes through the same states as a job, from queued to completed or failed.
+type Export struct {
+	ID    string `docstore:"id" json:"id"`
+	JobID string `docstore:"jobId" json:"jobId"`
+	// Owner is the owner of the job, copied so exports are authorized
+	// without reading it.
+	Owner      string    `docstore:"owner" json:"owner,omitempty"`
+	Format     Format    `docstore:"format" json:"format"`
+	State      job.State `docstore:"state" json:"state"`
+	Error      string    `docstore:"error" json:"error,omitempty"`
+	Attempts   int       `docstore:"attempts" json:"attempts"`
+	Key        string    `docstore:"key" json:"-"`
+	Size       int64     `docstore:"size" json:"size"`
+	Rows       int64     `docstore:"rows" json:"rows"`
+	CreatedAt  time.Time `docstore:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time `docstore:"updatedAt" json:"updatedAt"`
+	FinishedAt time.Time `docstore:"finishedAt" json:"finishedAt,omitempty"`
+
+	DocstoreRevision interface{} `json:"-"`
+}
+
+// Key is where the file of an export is written in the bucket.
+func Key(id string, f Format) string {
+	return fmt.Sprintf("exports/%s.%s", id, f)
+}
+
+func (e *Export) Transition(to job.State, reason string) error {
+	if !e.State.CanTransition(to) {
+		return fmt.Errorf("%w: %s -> %s", job.ErrInvalidTransition, e.State, to)
+	}
+	now := time.Now().UTC()
+	switch to {
+	case job.StateQueued:
+		e.Error = reason
+	case job.StateProcessing:
+		e.Attempts++
+		e.Error = ""
+	case job.StateCompleted:
+		e.FinishedAt = now
+	case job.StateFailed:
+		e.Error = reason
+		e.FinishedAt = now
+	}
+	e.State = to
+	return nil
+}
+
+type Store struct {
+	coll *docstore.Collection
+}
+
+func NewStore(coll *docstore.Collection) *Store {
+	return &Store{coll: coll}
+}
+
+func (s *Store) Close() error {
+	return s.coll.Close()
+}
+
+func (s *Store) Create(ctx context.Context, e *Export) error {
+	now := time.Now().UTC()
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	if e.State == "" {
+		e.State = job.StateQueued
+	}
+	if e.Key == "" {
+		e.Key = Key(e.ID, e.Format)
+	}
+	if err := s.coll.Create(ctx, e); err != nil {
+		return fmt.Errorf("failed to create export: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*Export, error) {
+	e := &Export{ID: id}
+	if err := s.coll.Get(ctx, e); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get export: %v", err)
+	}
+	return e, nil
+}
+
+// Update reads the export, applies fn and writes it back. The write is
+// rejected if the export was modified concurrently.
+func (s *Store) Update(ctx context.Context, id string, fn func(e *Export) error) (*Export, error) {
+	e, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(e); err != nil {
+		return e, err
+	}
+	e.UpdatedAt = time.Now().UTC()
+	if err := s.coll.Replace(ctx, e); err != nil {
+		return nil, fmt.Errorf("failed to update export: %v", err)
+	}
+	return e, nil
+}
+
+func (s *Store) Transition(ctx context.Context, id string, to job.State, reason string) (*Export, error) {
+	return s.Update(ctx, id, func(e *Export) error {
+		return e.Transition(to, reason)
+	})
+}
+
+// ListByJob returns the exports written from the documents of a job.
+func (s *Store) ListByJob(ctx context.Context, jobId string) ([]*Export, error) {
+	iter := s.coll.Query().Where("jobId", "=", jobId).Get(ctx)
+	defer iter.Stop()
+	var exports []*Export
+	for {
+		e := &Export{}
+		err := iter.Next(ctx, e)
+		if err == io.EOF {
+			return exports, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list exports: %v", err)
+		}
+		exports = append(exports, e)
+	}
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if err := s.coll.Delete(ctx, &Export{ID: id}); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete export: %v", err)
+	}
+	return nil
+}
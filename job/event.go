@@ -0,0 +1,70 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"go.opentelemetry.io/otel"
+	"gocloud.dev/pubsub"
+)
+
+type EventType string
+
+const (
+	EventStored    EventType = "stored"
+	EventParsed    EventType = "parsed"
+	EventInserted  EventType = "inserted"
+	EventCompleted EventType = "completed"
+	EventFailed    EventType = "failed"
+	EventDeleted   EventType = "deleted"
+)
+
+// Event reports the progress of a job while it is uploaded and ingested.
+type Event struct {
+	JobID           string    `json:"jobId"`
+	Type            EventType `json:"type"`
+	State           State     `json:"state,omitempty"`
+	BytesStored     int64     `json:"bytesStored,omitempty"`
+	LinesParsed     int64     `json:"linesParsed,omitempty"`
+	LinesRejected   int64     `json:"linesRejected,omitempty"`
+	BatchesInserted int64     `json:"batchesInserted,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+func (e Event) Final() bool {
+	return e.Type == EventCompleted || e.Type == EventFailed || e.Type == EventDeleted
+}
+
+func PublishEvent(ctx context.Context, topic *pubsub.Topic, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %v", err)
+	}
+	msg := &pubsub.Message{
+		Body: body,
+		Metadata: map[string]string{
+			"eventType": "job.progress",
+			"jobId":     e.JobID,
+		},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
+	if err := topic.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to publish event: %v", err)
+	}
+	return nil
+}
+
+func DecodeEvent(msg *pubsub.Message) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(msg.Body, &e); err != nil {
+		return e, fmt.Errorf("failed to decode event: %v", err)
+	}
+	return e, nil
+}
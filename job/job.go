@@ -0,0 +1,229 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+)
+
+type State string
+
+const (
+	StateUploaded   State = "uploaded"
+	StateQueued     State = "queued"
+	StateProcessing State = "processing"
+	StateCompleted  State = "completed"
+	StateFailed     State = "failed"
+	// StateDeleting jobs have their data being deleted, which ends in
+	// StateDeleted, where only the job record is left as a tombstone.
+	StateDeleting State = "deleting"
+	StateDeleted  State = "deleted"
+)
+
+// transitions lists the states reachable from each state. Processing can be
+// re-entered so a redelivered message is able to resume an interrupted job,
+// and goes back to queued when a failed attempt is retried or a finished job
+// is reprocessed. Deleting can be re-entered to retry a deletion. Jobs being
+// processed cannot be deleted until they are done.
+var transitions = map[State][]State{
+	StateUploaded:   {StateQueued, StateFailed, StateDeleting},
+	StateQueued:     {StateProcessing, StateFailed, StateDeleting},
+	StateProcessing: {StateProcessing, StateQueued, StateCompleted, StateFailed},
+	StateCompleted:  {StateQueued, StateDeleting},
+	StateFailed:     {StateQueued, StateDeleting},
+	StateDeleting:   {StateDeleting, StateDeleted},
+}
+
+// MaxLineErrors caps how many line errors are kept on a job record.
+const MaxLineErrors = 100
+
+var ErrInvalidTransition = errors.New("invalid job state transition")
+
+func (s State) CanTransition(to State) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal tells whether the current run of the job is over, though it can
+// still be reprocessed or deleted.
+func (s State) Terminal() bool {
+	return s == StateCompleted || s == StateFailed || s.Deleted()
+}
+
+// Deleted tells whether the data of the job is deleted or being deleted.
+func (s State) Deleted() bool {
+	return s == StateDeleting || s == StateDeleted
+}
+
+type Job struct {
+	ID       string `docstore:"id" json:"id"`
+	Filename string `docstore:"filename" json:"filename"`
+	Size     int64  `docstore:"size" json:"size"`
+	SHA256   string `docstore:"sha256" json:"sha256,omitempty"`
+	Uploader string `docstore:"uploader" json:"uploader"`
+	// Owner is the id of the API key that uploaded the file, the only one
+	// besides admin keys that can reach the job and its documents.
+	Owner      string    `docstore:"owner" json:"owner,omitempty"`
+	State      State     `docstore:"state" json:"state"`
+	Error      string    `docstore:"error" json:"error,omitempty"`
+	Attempts   int       `docstore:"attempts" json:"attempts"`
+	CreatedAt  time.Time `docstore:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time `docstore:"updatedAt" json:"updatedAt"`
+	StartedAt  time.Time `docstore:"startedAt" json:"startedAt,omitempty"`
+	FinishedAt time.Time `docstore:"finishedAt" json:"finishedAt,omitempty"`
+	// ExpiresAt is when the data of the job is deleted by the retention
+	// sweeper, never when zero.
+	ExpiresAt time.Time `docstore:"expiresAt" json:"expiresAt,omitempty"`
+
+	LinesProcessed int64       `docstore:"linesProcessed" json:"linesProcessed"`
+	LinesRejected  int64       `docstore:"linesRejected" json:"linesRejected"`
+	LineErrors     []LineError `docstore:"lineErrors" json:"lineErrors"`
+
+	// Schema holds the stored columns of the file and the type of each, either
+	// inferred or declared by SchemaDefinition.
+	Schema           []schema.Column    `docstore:"schema" json:"schema,omitempty"`
+	SchemaDefinition *schema.Definition `docstore:"schemaDefinition" json:"schemaDefinition,omitempty"`
+
+	// Parse tells how the file is read.
+	Parse parser.Options `docstore:"parse" json:"parse"`
+	// Source is the key of the file in the bucket when it is not stored
+	// under the id of the job, like the workbook of a sheet job.
+	Source string `docstore:"source" json:"source,omitempty"`
+
+	// An archive upload is expanded into a child job for every file it
+	// holds, linked to it by ParentID.
+	ParentID string   `docstore:"parentId" json:"parentId,omitempty"`
+	Children []string `docstore:"children" json:"children,omitempty"`
+
+	// Runs are the previous ingestions of the file, oldest first, kept
+	// when the job is reprocessed.
+	Runs []Run `docstore:"runs" json:"runs,omitempty"`
+
+	// DeletedAt is when the data of the job was deleted, DocumentsDeleted
+	// counting the documents removed along with its file.
+	DeletedAt        time.Time `docstore:"deletedAt" json:"deletedAt,omitempty"`
+	DocumentsDeleted int64     `docstore:"documentsDeleted" json:"documentsDeleted,omitempty"`
+
+	DocstoreRevision interface{} `json:"-"`
+}
+
+// Run is a finished ingestion of the job file, with the options it was read
+// with.
+type Run struct {
+	State          State           `docstore:"state" json:"state"`
+	Error          string          `docstore:"error" json:"error,omitempty"`
+	Attempts       int             `docstore:"attempts" json:"attempts"`
+	StartedAt      time.Time       `docstore:"startedAt" json:"startedAt,omitempty"`
+	FinishedAt     time.Time       `docstore:"finishedAt" json:"finishedAt,omitempty"`
+	LinesProcessed int64           `docstore:"linesProcessed" json:"linesProcessed"`
+	LinesRejected  int64           `docstore:"linesRejected" json:"linesRejected"`
+	Schema         []schema.Column `docstore:"schema" json:"schema,omitempty"`
+	Parse          parser.Options  `docstore:"parse" json:"parse"`
+	// ReprocessedAt is when the run was replaced by a new one.
+	ReprocessedAt time.Time `docstore:"reprocessedAt" json:"reprocessedAt"`
+
+	SchemaDefinition *schema.Definition `docstore:"schemaDefinition" json:"schemaDefinition,omitempty"`
+}
+
+type LineError struct {
+	Line    int    `docstore:"line" json:"line"`
+	Message string `docstore:"message" json:"message"`
+}
+
+// FileKey is where the file of the job is stored in the bucket.
+func (j *Job) FileKey() string {
+	if j.Source != "" {
+		return j.Source
+	}
+	return j.ID
+}
+
+func (j *Job) Transition(to State, reason string) error {
+	if !j.State.CanTransition(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, j.State, to)
+	}
+	now := time.Now().UTC()
+	j.State = to
+	j.UpdatedAt = now
+	switch to {
+	case StateQueued:
+		j.Error = reason
+	case StateProcessing:
+		j.Attempts++
+		j.StartedAt = now
+		j.Error = ""
+		j.LinesProcessed = 0
+		j.LinesRejected = 0
+		j.LineErrors = nil
+	case StateCompleted:
+		j.FinishedAt = now
+	case StateFailed:
+		j.FinishedAt = now
+		j.Error = reason
+	case StateDeleting:
+		j.Error = reason
+	case StateDeleted:
+		j.DeletedAt = now
+		j.Error = ""
+	}
+	return nil
+}
+
+// Reprocess records the finished run of the job in its history and queues
+// it again, reading the file with opts and def. Line errors of the previous
+// run are not kept.
+func (j *Job) Reprocess(opts parser.Options, def *schema.Definition) error {
+	if !j.State.Terminal() || j.State.Deleted() {
+		return fmt.Errorf("%w: job is %s", ErrInvalidTransition, j.State)
+	}
+	run := Run{
+		State:            j.State,
+		Error:            j.Error,
+		Attempts:         j.Attempts,
+		StartedAt:        j.StartedAt,
+		FinishedAt:       j.FinishedAt,
+		LinesProcessed:   j.LinesProcessed,
+		LinesRejected:    j.LinesRejected,
+		Schema:           j.Schema,
+		Parse:            j.Parse,
+		SchemaDefinition: j.SchemaDefinition,
+	}
+	if err := j.Transition(StateQueued, ""); err != nil {
+		return err
+	}
+	run.ReprocessedAt = j.UpdatedAt
+	j.Runs = append(j.Runs, run)
+	j.Attempts = 0
+	j.StartedAt = time.Time{}
+	j.FinishedAt = time.Time{}
+	j.LinesProcessed = 0
+	j.LinesRejected = 0
+	j.LineErrors = nil
+	j.Schema = nil
+	j.Parse = opts
+	j.SchemaDefinition = def
+	return nil
+}
+
+// RejectLine counts a line that could not be ingested, keeping the error
+// message while the job holds less than MaxLineErrors of them.
+func (j *Job) RejectLine(line int, message string) {
+	j.LinesRejected++
+	if len(j.LineErrors) < MaxLineErrors {
+		j.LineErrors = append(j.LineErrors, LineError{Line: line, Message: message})
+	}
+}
+
+// RecordID is the document id of the row at the given line of a job file.
+// It is deterministic so reprocessing a job overwrites the same documents,
+// and zero padded so ids sort in line order.
+func RecordID(jobId string, line int) string {
+	return fmt.Sprintf("%s-%010d", jobId, line)
+}
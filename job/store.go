@@ -0,0 +1,114 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+var ErrNotFound = errors.New("job not found")
+
+type Store struct {
+	coll *docstore.Collection
+}
+
+func NewStore(coll *docstore.Collection) *Store {
+	return &Store{coll: coll}
+}
+
+func (s *Store) Close() error {
+	return s.coll.Close()
+}
+
+func (s *Store) Create(ctx context.Context, j *Job) error {
+	now := time.Now().UTC()
+	j.CreatedAt = now
+	j.UpdatedAt = now
+	if j.State == "" {
+		j.State = StateUploaded
+	}
+	if err := s.coll.Create(ctx, j); err != nil {
+		return fmt.Errorf("failed to create job: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*Job, error) {
+	j := &Job{ID: id}
+	if err := s.coll.Get(ctx, j); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get job: %v", err)
+	}
+	return j, nil
+}
+
+// FindByHash returns a job of owner whose file has the given SHA-256, leaving
+// out failed and deleted ones, or ErrNotFound when there is none.
+func (s *Store) FindByHash(ctx context.Context, owner, sum string) (*Job, error) {
+	iter := s.coll.Query().Where("sha256", "=", sum).Get(ctx)
+	defer iter.Stop()
+	for {
+		j := &Job{}
+		err := iter.Next(ctx, j)
+		if err == io.EOF {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find job: %v", err)
+		}
+		if j.Owner == owner && j.State != StateFailed && !j.State.Deleted() {
+			return j, nil
+		}
+	}
+}
+
+// Each calls fn with every job, stopping at the first error it returns.
+func (s *Store) Each(ctx context.Context, fn func(j *Job) error) error {
+	iter := s.coll.Query().Get(ctx)
+	defer iter.Stop()
+	for {
+		j := &Job{}
+		err := iter.Next(ctx, j)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list jobs: %v", err)
+		}
+		if err := fn(j); err != nil {
+			return err
+		}
+	}
+}
+
+// Update reads the job, applies fn and writes it back. The write is rejected
+// if the job was modified concurrently.
+func (s *Store) Update(ctx context.Context, id string, fn func(j *Job) error) (*Job, error) {
+	j, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(j); err != nil {
+		return j, err
+	}
+	j.UpdatedAt = time.Now().UTC()
+	if err := s.coll.Replace(ctx, j); err != nil {
+		return nil, fmt.Errorf("failed to update job: %v", err)
+	}
+	return j, nil
+}
+
+// Transition moves the job to the given state, recording the reason when it
+// fails.
+func (s *Store) Transition(ctx context.Context, id string, to State, reason string) (*Job, error) {
+	return s.Update(ctx, id, func(j *Job) error {
+		return j.Transition(to, reason)
+	})
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+	CompressionZip  Compression = "zip"
+)
+
+var magics = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionZip, []byte("PK\x03\x04")},
+	// An empty archive only holds the end of central directory record.
+	{CompressionZip, []byte("PK\x05\x06")},
+}
+
+// ErrArchive is returned by Decompress for archives, whose files are read
+// with random access rather than as a stream.
+var ErrArchive = errors.New("file is an archive")
+
+// Sniff tells the compression of r from its first bytes, without consuming
+// them.
+func Sniff(r *bufio.Reader) (Compression, error) {
+	head, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return CompressionNone, err
+	}
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.compression, nil
+		}
+	}
+	return CompressionNone, nil
+}
+
+// Decompress returns a reader of the decompressed content of r, detected by
+// magic bytes. Closing it does not close r.
+func Decompress(r io.Reader) (io.ReadCloser, Compression, error) {
+	br := bufio.NewReader(r)
+	c, err := Sniff(br)
+	if err != nil {
+		return nil, c, fmt.Errorf("failed to read file: %v", err)
+	}
+	switch c {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, c, fmt.Errorf("invalid gzip file: %v", err)
+		}
+		return zr, c, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, c, fmt.Errorf("invalid zstd file: %v", err)
+		}
+		return zr.IOReadCloser(), c, nil
+	case CompressionZip:
+		return nil, c, ErrArchive
+	}
+	return ioutil.NopCloser(br), c, nil
+}
@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type csvReader struct {
+	csv    *csv.Reader
+	header []string
+	line   int
+}
+
+func newCSVReader(r io.Reader, comma rune) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.LazyQuotes = true
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	names := make([]string, len(header))
+	for i, h := range header {
+		names[i] = clean(h)
+	}
+	return &csvReader{csv: cr, header: names, line: 1}, nil
+}
+
+func (r *csvReader) Header() []string {
+	return r.header
+}
+
+func (r *csvReader) Next() ([]string, error) {
+	values, err := r.csv.Read()
+	if err != nil {
+		if pe, ok := err.(*csv.ParseError); ok {
+			r.line++
+			return nil, &LineError{Line: pe.StartLine, Err: pe.Err}
+		}
+		return nil, err
+	}
+	r.line++
+	for i, v := range values {
+		values[i] = clean(v)
+	}
+	return values, nil
+}
+
+func (r *csvReader) Line() int {
+	return r.line
+}
+
+// clean drops the quotes lazy quoting leaves in fields and the spaces around
+// them.
+func clean(v string) string {
+	return strings.TrimSpace(strings.ReplaceAll(v, "\"", ""))
+}
@@ -0,0 +1,261 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type field struct {
+	key   string
+	value json.RawMessage
+}
+
+// parseObject decodes a JSON object keeping the order of its keys.
+func parseObject(raw []byte) ([]field, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, errors.New("expected a JSON object")
+	}
+	var fields []field
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field{key: key, value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+	return fields, nil
+}
+
+// valueString turns a JSON value into the text the schema types parse.
+// Nested objects and arrays are kept as compact JSON.
+func valueString(raw json.RawMessage) (string, error) {
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return "", nil
+	case raw[0] == '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case raw[0] == '{' || raw[0] == '[':
+		var buf bytes.Buffer
+		err := json.Compact(&buf, raw)
+		return buf.String(), err
+	}
+	return string(raw), nil
+}
+
+// objectRows lays out JSON objects as rows under the keys of the first one.
+// Later objects may leave keys out, but a key the first object does not have
+// rejects the row like a CSV row with extra fields.
+type objectRows struct {
+	header []string
+	index  map[string]int
+	values []string
+}
+
+func newObjectRows(first []field) *objectRows {
+	o := &objectRows{index: map[string]int{}}
+	for _, f := range first {
+		if _, ok := o.index[f.key]; !ok {
+			o.index[f.key] = len(o.header)
+			o.header = append(o.header, f.key)
+		}
+	}
+	o.values = make([]string, len(o.header))
+	return o
+}
+
+func (o *objectRows) row(fields []field) ([]string, error) {
+	for i := range o.values {
+		o.values[i] = ""
+	}
+	for _, f := range fields {
+		i, ok := o.index[f.key]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q, fields are set by the first object", f.key)
+		}
+		v, err := valueString(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", f.key, err)
+		}
+		o.values[i] = v
+	}
+	return o.values, nil
+}
+
+// ndjsonReader reads one JSON object per line, skipping blank lines.
+type ndjsonReader struct {
+	r       *bufio.Reader
+	rows    *objectRows
+	line    int
+	first   []field
+	lastErr error
+}
+
+func newNDJSONReader(r io.Reader) (*ndjsonReader, error) {
+	nr := &ndjsonReader{r: bufio.NewReader(r)}
+	raw, err := nr.readLine()
+	if err == io.EOF {
+		return nil, errors.New("failed to read header: the file holds no objects")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	first, err := parseObject(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: line %d: %v", nr.line, err)
+	}
+	nr.first = first
+	nr.rows = newObjectRows(first)
+	return nr, nil
+}
+
+// readLine returns the next line that is not blank.
+func (nr *ndjsonReader) readLine() ([]byte, error) {
+	for {
+		if nr.lastErr != nil {
+			return nil, nr.lastErr
+		}
+		raw, err := nr.r.ReadBytes('\n')
+		if err != nil {
+			nr.lastErr = err
+			if err != io.EOF {
+				return nil, err
+			}
+		}
+		if len(raw) > 0 {
+			nr.line++
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 {
+			return raw, nil
+		}
+	}
+}
+
+func (nr *ndjsonReader) Header() []string {
+	return nr.rows.header
+}
+
+func (nr *ndjsonReader) Next() ([]string, error) {
+	if nr.first != nil {
+		fields := nr.first
+		nr.first = nil
+		return nr.rows.row(fields)
+	}
+	raw, err := nr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := parseObject(raw)
+	if err != nil {
+		return nil, &LineError{Line: nr.line, Err: err}
+	}
+	values, err := nr.rows.row(fields)
+	if err != nil {
+		return nil, &LineError{Line: nr.line, Err: err}
+	}
+	return values, nil
+}
+
+func (nr *ndjsonReader) Line() int {
+	return nr.line
+}
+
+// jsonReader reads a JSON array of objects one element at a time. Its rows
+// are numbered by their position in the array.
+type jsonReader struct {
+	dec   *json.Decoder
+	rows  *objectRows
+	line  int
+	first []field
+}
+
+func newJSONReader(r io.Reader) (*jsonReader, error) {
+	jr := &jsonReader{dec: json.NewDecoder(r)}
+	tok, err := jr.dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, errors.New("failed to read header: expected a JSON array of objects")
+	}
+	if !jr.dec.More() {
+		return nil, errors.New("failed to read header: the file holds no objects")
+	}
+	raw, err := jr.element()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	first, err := parseObject(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: element %d: %v", jr.line, err)
+	}
+	jr.first = first
+	jr.rows = newObjectRows(first)
+	return jr, nil
+}
+
+func (jr *jsonReader) element() (json.RawMessage, error) {
+	var raw json.RawMessage
+	if err := jr.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	jr.line++
+	return raw, nil
+}
+
+func (jr *jsonReader) Header() []string {
+	return jr.rows.header
+}
+
+func (jr *jsonReader) Next() ([]string, error) {
+	if jr.first != nil {
+		fields := jr.first
+		jr.first = nil
+		return jr.rows.row(fields)
+	}
+	if !jr.dec.More() {
+		if _, err := jr.dec.Token(); err != nil {
+			return nil, fmt.Errorf("failed to read array end: %v", err)
+		}
+		return nil, io.EOF
+	}
+	raw, err := jr.element()
+	if err != nil {
+		// The array itself is malformed, nothing after it can be read.
+		return nil, err
+	}
+	fields, err := parseObject(raw)
+	if err != nil {
+		return nil, &LineError{Line: jr.line, Err: err}
+	}
+	values, err := jr.rows.row(fields)
+	if err != nil {
+		return nil, &LineError{Line: jr.line, Err: err}
+	}
+	return values, nil
+}
+
+func (jr *jsonReader) Line() int {
+	return jr.line
+}
@@ -0,0 +1,175 @@
+// Package parser reads the rows of uploaded files in the formats the service
+// accepts.
+package parser
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatTSV    Format = "tsv"
+	FormatNDJSON Format = "ndjson"
+	FormatJSON   Format = "json"
+	FormatXLSX   Format = "xlsx"
+)
+
+var contentTypes = map[string]Format{
+	"text/csv":                  FormatCSV,
+	"application/csv":           FormatCSV,
+	"text/tab-separated-values": FormatTSV,
+	"application/x-ndjson":      FormatNDJSON,
+	"application/jsonl":         FormatNDJSON,
+	"application/x-jsonlines":   FormatNDJSON,
+	"application/json":          FormatJSON,
+
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": FormatXLSX,
+}
+
+var extensions = map[string]Format{
+	".csv":    FormatCSV,
+	".tsv":    FormatTSV,
+	".tab":    FormatTSV,
+	".ndjson": FormatNDJSON,
+	".jsonl":  FormatNDJSON,
+	".json":   FormatJSON,
+	".xlsx":   FormatXLSX,
+}
+
+// Options tell how a file is parsed. They are kept on the job so the worker
+// reads the file the way it was validated on upload.
+type Options struct {
+	Format Format `docstore:"format" json:"format"`
+	// Delimiter separates the fields of CSV files, a comma by default.
+	Delimiter string `docstore:"delimiter" json:"delimiter,omitempty"`
+	// Compression is how the stored file is compressed, found from its
+	// content on upload.
+	Compression Compression `docstore:"compression" json:"compression,omitempty"`
+	// Sheet names the sheet of an xlsx workbook to read, the first one by
+	// default, or AllSheets.
+	Sheet string `docstore:"sheet" json:"sheet,omitempty"`
+}
+
+// Detect picks the format of a file from format when given, then from its
+// content type and then from the extension of its name, defaulting to CSV.
+func Detect(format, contentType, filename string) (Format, error) {
+	if format != "" {
+		switch f := Format(strings.ToLower(format)); f {
+		case FormatCSV, FormatTSV, FormatNDJSON, FormatJSON, FormatXLSX:
+			return f, nil
+		}
+		return "", fmt.Errorf("unknown format %q, use one of: csv, tsv, ndjson, json, xlsx", format)
+	}
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		if f, ok := contentTypes[mt]; ok {
+			return f, nil
+		}
+	}
+	if f, ok := FormatOf(filename); ok {
+		return f, nil
+	}
+	return FormatCSV, nil
+}
+
+// compressedExtensions are looked past to find the format of a file, as in
+// data.ndjson.gz.
+var compressedExtensions = map[string]bool{
+	".gz":   true,
+	".gzip": true,
+	".zst":  true,
+	".zstd": true,
+}
+
+// FormatOf tells the format of a file from the extension of its name.
+func FormatOf(filename string) (Format, bool) {
+	name := strings.ToLower(filename)
+	if ext := path.Ext(name); compressedExtensions[ext] {
+		name = strings.TrimSuffix(name, ext)
+	}
+	f, ok := extensions[path.Ext(name)]
+	return f, ok
+}
+
+// Validate checks the options and fills in their defaults.
+func (o *Options) Validate() error {
+	if o.Format == "" {
+		o.Format = FormatCSV
+	}
+	if o.Delimiter != "" {
+		if o.Format != FormatCSV {
+			return fmt.Errorf("delimiter is only supported for csv files")
+		}
+		if _, err := delimiter(o.Delimiter); err != nil {
+			return err
+		}
+	}
+	if o.Sheet != "" && o.Format != FormatXLSX {
+		return fmt.Errorf("sheet is only supported for xlsx files")
+	}
+	return nil
+}
+
+func delimiter(d string) (rune, error) {
+	r, size := utf8.DecodeRuneInString(d)
+	if size != len(d) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q", d)
+	}
+	return r, nil
+}
+
+// LineError is a problem with a single row of the file, which is rejected
+// while the rest of the file is still read.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Reader reads a file as rows of string values under a header.
+type Reader interface {
+	// Header returns the column names, as written in the file.
+	Header() []string
+	// Next returns the values of the next row in the order of the header,
+	// io.EOF at the end of the file or a *LineError when only the current
+	// row is invalid. The returned slice may be reused by the following call.
+	Next() ([]string, error)
+	// Line returns the line of the row last returned, or its position for
+	// formats that are not line based.
+	Line() int
+}
+
+// NewReader reads the header of r and returns a reader for its rows. r must
+// already be decompressed, see Decompress. Workbooks need random access and
+// are read with OpenWorkbook instead.
+func NewReader(r io.Reader, opts Options) (Reader, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	switch opts.Format {
+	case FormatCSV:
+		comma := ','
+		if opts.Delimiter != "" {
+			comma, _ = delimiter(opts.Delimiter)
+		}
+		return newCSVReader(r, comma)
+	case FormatTSV:
+		return newCSVReader(r, '\t')
+	case FormatNDJSON:
+		return newNDJSONReader(r)
+	case FormatJSON:
+		return newJSONReader(r)
+	case FormatXLSX:
+		return nil, fmt.Errorf("xlsx files cannot be read as a stream")
+	}
+	return nil, fmt.Errorf("unknown format %q", opts.Format)
+}
@@ -0,0 +1,537 @@
+package parser
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AllSheets as the sheet option ingests every sheet of a workbook as a job
+// of its own. Sheet names cannot hold a '*'.
+const AllSheets = "*"
+
+// Parts of a workbook, at the paths spreadsheet applications write them.
+const (
+	workbookPath      = "xl/workbook.xml"
+	workbookRelsPath  = "xl/_rels/workbook.xml.rels"
+	sharedStringsPath = "xl/sharedStrings.xml"
+	stylesPath        = "xl/styles.xml"
+)
+
+// The size of a sheet, A1 to XFD1048576.
+const (
+	maxSheetColumns = 16384
+	maxSheetRows    = 1048576
+)
+
+// Workbook is an xlsx file, a zip archive of XML parts. Only its shared
+// strings and styles are kept in memory, sheets are read as a stream.
+type Workbook struct {
+	zip      *zip.Reader
+	sheets   []sheetRef
+	strings  []string
+	dates    []bool
+	date1904 bool
+}
+
+type sheetRef struct {
+	name string
+	path string
+}
+
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// xlsxText is rich text, either a single run or several of them.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxCell struct {
+	Ref    string    `xml:"r,attr"`
+	Type   string    `xml:"t,attr"`
+	Style  int       `xml:"s,attr"`
+	Value  string    `xml:"v"`
+	Inline *xlsxText `xml:"is"`
+}
+
+// OpenWorkbook reads the sheet list, shared strings and styles of an xlsx
+// file.
+func OpenWorkbook(ra io.ReaderAt, size int64) (*Workbook, error) {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %v", err)
+	}
+	wb := &Workbook{zip: zr}
+
+	var book xlsxWorkbook
+	if err := wb.decode(workbookPath, &book); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := wb.decode(workbookRelsPath, &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, r := range rels.Relationships {
+		if strings.HasPrefix(r.Target, "/") {
+			targets[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			targets[r.ID] = path.Join(path.Dir(workbookPath), r.Target)
+		}
+	}
+	for _, s := range book.Sheets {
+		p, ok := targets[s.RID]
+		if !ok {
+			return nil, fmt.Errorf("invalid xlsx file: sheet %q has no part", s.Name)
+		}
+		wb.sheets = append(wb.sheets, sheetRef{name: s.Name, path: p})
+	}
+	wb.date1904 = book.Properties.Date1904
+
+	if err := wb.readSharedStrings(); err != nil {
+		return nil, err
+	}
+	if err := wb.readStyles(); err != nil {
+		return nil, err
+	}
+	return wb, nil
+}
+
+func (wb *Workbook) file(name string) *zip.File {
+	for _, f := range wb.zip.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (wb *Workbook) decode(name string, v interface{}) error {
+	f := wb.file(name)
+	if f == nil {
+		return fmt.Errorf("invalid xlsx file: missing %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("invalid xlsx file: %v", err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("invalid xlsx file: %s: %v", name, err)
+	}
+	return nil
+}
+
+// readSharedStrings loads the table text cells point into. Workbooks without
+// text have none.
+func (wb *Workbook) readSharedStrings() error {
+	f := wb.file(sharedStringsPath)
+	if f == nil {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("invalid xlsx file: %v", err)
+	}
+	defer rc.Close()
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid xlsx file: %s: %v", sharedStringsPath, err)
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "si" {
+			var t xlsxText
+			if err := dec.DecodeElement(&t, &se); err != nil {
+				return fmt.Errorf("invalid xlsx file: %s: %v", sharedStringsPath, err)
+			}
+			wb.strings = append(wb.strings, t.String())
+		}
+	}
+}
+
+// readStyles finds which cell styles show numbers as dates, since the file
+// itself stores dates as numbers.
+func (wb *Workbook) readStyles() error {
+	if wb.file(stylesPath) == nil {
+		return nil
+	}
+	var styles xlsxStyles
+	if err := wb.decode(stylesPath, &styles); err != nil {
+		return err
+	}
+	custom := make(map[int]string, len(styles.NumFmts))
+	for _, nf := range styles.NumFmts {
+		custom[nf.ID] = nf.Code
+	}
+	wb.dates = make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			wb.dates[i] = isDateFormat(code)
+		} else {
+			wb.dates[i] = isBuiltinDateFormat(xf.NumFmtID)
+		}
+	}
+	return nil
+}
+
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// isDateFormat tells whether a number format code shows a date or a time,
+// looking for their placeholders outside of literal text and of bracketed
+// sections like colors.
+func isDateFormat(code string) bool {
+	// Only the format of positive numbers matters.
+	if i := strings.IndexByte(code, ';'); i >= 0 {
+		code = code[:i]
+	}
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case '"':
+			if j := strings.IndexByte(code[i+1:], '"'); j >= 0 {
+				i += j + 1
+			} else {
+				return false
+			}
+		case '\\', '_', '*':
+			i++
+		case '[':
+			if j := strings.IndexByte(code[i:], ']'); j >= 0 {
+				// Elapsed time, as in [h]:mm.
+				section := strings.ToLower(code[i+1 : i+j])
+				if strings.Trim(section, "hms") == "" && section != "" {
+					return true
+				}
+				i += j
+			}
+		case 'd', 'D', 'm', 'M', 'y', 'Y', 'h', 'H', 's', 'S':
+			return true
+		}
+	}
+	return false
+}
+
+// Sheets returns the names of the sheets of the workbook, in order.
+func (wb *Workbook) Sheets() []string {
+	names := make([]string, len(wb.sheets))
+	for i, s := range wb.sheets {
+		names[i] = s.name
+	}
+	return names
+}
+
+// SheetReader reads the rows of a workbook sheet. Close releases the sheet,
+// not the workbook file.
+type SheetReader interface {
+	Reader
+	io.Closer
+}
+
+// NewReader reads the header of a sheet, the first one when sheet is empty,
+// and returns a reader for its rows. The first row that is not blank is the
+// header and Line is the row number shown by spreadsheet applications.
+func (wb *Workbook) NewReader(sheet string) (SheetReader, error) {
+	if len(wb.sheets) == 0 {
+		return nil, errors.New("invalid xlsx file: workbook has no sheets")
+	}
+	ref := wb.sheets[0]
+	if sheet != "" {
+		found := false
+		for _, s := range wb.sheets {
+			if s.name == sheet {
+				ref, found = s, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("sheet %q not found, the workbook has: %s", sheet, strings.Join(wb.Sheets(), ", "))
+		}
+	}
+	f := wb.file(ref.path)
+	if f == nil {
+		return nil, fmt.Errorf("invalid xlsx file: missing %s", ref.path)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %v", err)
+	}
+	sr := &sheetReader{wb: wb, rc: rc, dec: xml.NewDecoder(rc)}
+	if err := sr.readHeader(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return sr, nil
+}
+
+type sheetReader struct {
+	wb     *Workbook
+	rc     io.ReadCloser
+	dec    *xml.Decoder
+	header []string
+	values []string
+	cells  []string
+	row    int
+}
+
+func (r *sheetReader) readHeader() error {
+	for {
+		cells, err := r.readRow()
+		if err == io.EOF {
+			return errors.New("failed to read header: sheet is empty")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read header: %v", err)
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		r.header = make([]string, len(cells))
+		for i, c := range cells {
+			r.header[i] = clean(c)
+		}
+		r.values = make([]string, len(r.header))
+		return nil
+	}
+}
+
+func (r *sheetReader) Header() []string {
+	return r.header
+}
+
+// Next skips blank rows. A value past the last column of the header rejects
+// the row, like extra fields do in CSV files.
+func (r *sheetReader) Next() ([]string, error) {
+	for {
+		cells, err := r.readRow()
+		if err != nil {
+			return nil, err
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		if len(cells) > len(r.header) {
+			return nil, &LineError{Line: r.row, Err: fmt.Errorf("value in column %s past the header", columnName(len(cells)-1))}
+		}
+		for i := range r.values {
+			r.values[i] = ""
+		}
+		copy(r.values, cells)
+		return r.values, nil
+	}
+}
+
+func (r *sheetReader) Line() int {
+	return r.row
+}
+
+func (r *sheetReader) Close() error {
+	return r.rc.Close()
+}
+
+// readRow returns the values of the next row up to its last non-empty cell,
+// so blank rows have none.
+func (r *sheetReader) readRow() ([]string, error) {
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "row" {
+				return r.decodeRow(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "sheetData" {
+				return nil, io.EOF
+			}
+		}
+	}
+}
+
+// decodeRow reads the cells of a row. A row or cell reference outside of
+// the sheet rejects the row, the rest of which is skipped.
+func (r *sheetReader) decodeRow(start xml.StartElement) ([]string, error) {
+	r.row++
+	for _, a := range start.Attr {
+		if a.Name.Local == "r" {
+			n, err := strconv.Atoi(a.Value)
+			if err != nil || n < 1 || n > maxSheetRows {
+				return nil, r.skipRow(fmt.Errorf("invalid row number %q", a.Value))
+			}
+			r.row = n
+		}
+	}
+	r.cells = r.cells[:0]
+	last := 0
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				if err := r.dec.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			var c xlsxCell
+			if err := r.dec.DecodeElement(&c, &t); err != nil {
+				return nil, err
+			}
+			col := len(r.cells)
+			if c.Ref != "" {
+				if col, err = columnIndex(c.Ref); err != nil {
+					return nil, r.skipRow(err)
+				}
+			}
+			for len(r.cells) <= col {
+				r.cells = append(r.cells, "")
+			}
+			r.cells[col] = r.wb.cellValue(&c)
+			if r.cells[col] != "" {
+				last = col + 1
+			}
+		case xml.EndElement:
+			return r.cells[:last], nil
+		}
+	}
+}
+
+// skipRow moves past the rest of the row being decoded, returning err as
+// the error of the row.
+func (r *sheetReader) skipRow(err error) error {
+	if serr := r.dec.Skip(); serr != nil {
+		return serr
+	}
+	return &LineError{Line: r.row, Err: err}
+}
+
+// cellValue turns a cell into the text the schema types parse: numbers as
+// written, dates as timestamps, booleans as true and false, and errors like
+// #DIV/0! as empty values.
+func (wb *Workbook) cellValue(c *xlsxCell) string {
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(c.Value)
+		if err != nil || i < 0 || i >= len(wb.strings) {
+			return ""
+		}
+		return wb.strings[i]
+	case "inlineStr":
+		if c.Inline == nil {
+			return ""
+		}
+		return c.Inline.String()
+	case "b":
+		return strconv.FormatBool(c.Value == "1")
+	case "e":
+		return ""
+	case "str", "d":
+		return c.Value
+	}
+	if c.Value != "" && c.Style >= 0 && c.Style < len(wb.dates) && wb.dates[c.Style] {
+		if f, err := strconv.ParseFloat(c.Value, 64); err == nil {
+			return wb.formatDate(f)
+		}
+	}
+	return c.Value
+}
+
+// formatDate converts a serial date, days since the epoch of the workbook
+// with the time of day as the fraction, in a layout schema.Detect knows.
+func (wb *Workbook) formatDate(serial float64) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if wb.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(serial)
+	ms := math.Round((serial - days) * 24 * 60 * 60 * 1000)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond)
+	if ms == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// columnIndex returns the zero based column of a cell reference like AB12.
+// References past the last column of a sheet, XFD, are invalid.
+func columnIndex(ref string) (int, error) {
+	col := 0
+	i := 0
+	for ; i < len(ref); i++ {
+		c := ref[i] | 0x20
+		if c < 'a' || c > 'z' {
+			break
+		}
+		col = col*26 + int(c-'a'+1)
+		if col > maxSheetColumns {
+			return 0, fmt.Errorf("cell reference %q is past column %s", ref, columnName(maxSheetColumns-1))
+		}
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return col - 1, nil
+}
+
+func columnName(col int) string {
+	var b []byte
+	for col++; col > 0; col = (col - 1) / 26 {
+		b = append([]byte{byte('A' + (col-1)%26)}, b...)
+	}
+	return string(b)
+}
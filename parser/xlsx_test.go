@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref     string
+		col     int
+		invalid bool
+	}{
+		{ref: "A1", col: 0},
+		{ref: "b7", col: 1},
+		{ref: "Z3", col: 25},
+		{ref: "AA3", col: 26},
+		{ref: "AB12", col: 27},
+		{ref: "XFD1", col: 16383},
+		{ref: "XFE1", invalid: true},
+		{ref: "ZZZZZZZZZZZZZZ1", invalid: true},
+		{ref: "12", invalid: true},
+		{ref: "", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			col, err := columnIndex(tt.ref)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("column %d, want an error", col)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if col != tt.col {
+				t.Fatalf("column %d, want %d", col, tt.col)
+			}
+		})
+	}
+}
+
+func TestDecodeRow(t *testing.T) {
+	type row struct {
+		line   int
+		values []string
+		// invalid rows are rejected alone, with a *LineError.
+		invalid bool
+	}
+	tests := []struct {
+		name string
+		rows string
+		want []row
+	}{
+		{
+			name: "in order",
+			rows: `<row r="1"><c r="A1"><v>1</v></c><c r="B1" t="inlineStr"><is><t>x</t></is></c></row>`,
+			want: []row{{line: 1, values: []string{"1", "x"}}},
+		},
+		{
+			name: "without references",
+			rows: `<row><c><v>1</v></c><c t="b"><v>1</v></c></row>`,
+			want: []row{{line: 1, values: []string{"1", "true"}}},
+		},
+		{
+			name: "sparse cells",
+			rows: `<row r="4"><c r="C4"><v>3</v></c></row>`,
+			want: []row{{line: 4, values: []string{"", "", "3"}}},
+		},
+		{
+			name: "trailing empty cells",
+			rows: `<row r="1"><c r="A1"><v>1</v></c><c r="B1"/></row>`,
+			want: []row{{line: 1, values: []string{"1"}}},
+		},
+		{
+			name: "cell past the last column",
+			rows: `<row r="1"><c r="XFE1"><v>1</v></c><c r="A1"><v>2</v></c></row>` +
+				`<row r="2"><c r="A2"><v>3</v></c></row>`,
+			want: []row{{line: 1, invalid: true}, {line: 2, values: []string{"3"}}},
+		},
+		{
+			name: "row past the last row",
+			rows: `<row r="1048577"><c r="A1"><v>1</v></c></row>` +
+				`<row r="2"><c r="A2"><v>3</v></c></row>`,
+			want: []row{{invalid: true}, {line: 2, values: []string{"3"}}},
+		},
+		{
+			name: "invalid row number",
+			rows: `<row r="-1"><c><v>1</v></c></row>`,
+			want: []row{{invalid: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := `<worksheet><sheetData>` + tt.rows + `</sheetData></worksheet>`
+			r := &sheetReader{wb: &Workbook{}, dec: xml.NewDecoder(strings.NewReader(doc))}
+			for i, want := range tt.want {
+				values, err := r.readRow()
+				var le *LineError
+				if want.invalid {
+					if !errors.As(err, &le) {
+						t.Fatalf("row %d: error %v, want a *LineError", i, err)
+					}
+					if want.line != 0 && le.Line != want.line {
+						t.Fatalf("row %d: error on line %d, want %d", i, le.Line, want.line)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("row %d: %v", i, err)
+				}
+				if r.Line() != want.line {
+					t.Fatalf("row %d: line %d, want %d", i, r.Line(), want.line)
+				}
+				if !reflect.DeepEqual(values, want.values) {
+					t.Fatalf("row %d: values %q, want %q", i, values, want.values)
+				}
+			}
+			if _, err := r.readRow(); err != io.EOF {
+				t.Fatalf("error %v after the last row, want io.EOF", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Package purge deletes the data of jobs: their file, the documents ingested
+// from it and the exports written from them. The job record is kept as a
+// tombstone.
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"gocloud.dev/blob"
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+// EventRequested is the eventType of the messages asking the worker to
+// delete the data of a job.
+const EventRequested = "job.delete"
+
+type Purger struct {
+	jobs    *job.Store
+	exports *export.Store
+	bucket  *blob.Bucket
+	// colls hold the documents of jobs, keyed by job.RecordID.
+	colls     []*docstore.Collection
+	batchSize int
+}
+
+// New returns a Purger deleting the documents of jobs from coll and
+// quarantine batchSize at a time.
+func New(jobs *job.Store, exports *export.Store, bucket *blob.Bucket, coll, quarantine *docstore.Collection, batchSize int) *Purger {
+	return &Purger{
+		jobs:      jobs,
+		exports:   exports,
+		bucket:    bucket,
+		colls:     []*docstore.Collection{coll, quarantine},
+		batchSize: batchSize,
+	}
+}
+
+// Start marks the job as being deleted, which keeps it from being processed
+// and reused as a duplicate. It fails with job.ErrInvalidTransition while
+// the job is being processed or once it is deleted.
+func (p *Purger) Start(ctx context.Context, jobId string) (*job.Job, error) {
+	return p.jobs.Transition(ctx, jobId, job.StateDeleting, "")
+}
+
+// Purge deletes the data of a job marked by Start, then the data of its
+// children, and leaves the job deleted. It can be called again after
+// failing to resume.
+func (p *Purger) Purge(ctx context.Context, jobId string) (*job.Job, error) {
+	j, err := p.jobs.Get(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	if j.State == job.StateDeleted {
+		return j, nil
+	}
+	if j.State != job.StateDeleting {
+		return nil, fmt.Errorf("%w: job is %s, not %s", job.ErrInvalidTransition, j.State, job.StateDeleting)
+	}
+
+	for _, childId := range j.Children {
+		_, err := p.Start(ctx, childId)
+		if err != nil && !errors.Is(err, job.ErrInvalidTransition) && !errors.Is(err, job.ErrNotFound) {
+			return nil, err
+		}
+		if _, err := p.Purge(ctx, childId); err != nil && !errors.Is(err, job.ErrNotFound) {
+			// A child being processed is left for another attempt.
+			return nil, fmt.Errorf("failed to delete child job %s: %v", childId, err)
+		}
+	}
+
+	if err := p.bucket.Delete(ctx, jobId); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, fmt.Errorf("failed to delete file: %v", err)
+	}
+	exports, err := p.exports.ListByJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range exports {
+		if err := p.bucket.Delete(ctx, e.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return nil, fmt.Errorf("failed to delete export file: %v", err)
+		}
+		if err := p.exports.Delete(ctx, e.ID); err != nil && !errors.Is(err, export.ErrNotFound) {
+			return nil, err
+		}
+	}
+	deleted, err := p.DeleteDocuments(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.jobs.Update(ctx, jobId, func(current *job.Job) error {
+		// Documents deleted by a failed attempt are not found again, so
+		// only the last attempt is counted.
+		current.DocumentsDeleted = deleted
+		return current.Transition(job.StateDeleted, "")
+	})
+}
+
+// DeleteDocuments removes the documents ingested from the file of a job,
+// rejected ones included, and returns how many there were.
+func (p *Purger) DeleteDocuments(ctx context.Context, jobId string) (int64, error) {
+	var deleted int64
+	for _, coll := range p.colls {
+		n, err := p.deleteDocuments(ctx, coll, jobId)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteDocuments removes the documents of a job in batches of batchSize,
+// querying again after every batch so no cursor is kept open over the
+// documents being deleted.
+func (p *Purger) deleteDocuments(ctx context.Context, coll *docstore.Collection, jobId string) (int64, error) {
+	var deleted int64
+	for {
+		// Record ids are the job id followed by the line number, so this
+		// range holds exactly the job documents.
+		iter := coll.Query().
+			Where("id", ">", jobId+"-").
+			Where("id", "<", jobId+".").
+			Limit(p.batchSize).
+			Get(ctx, "id")
+		actions := coll.Actions()
+		n := 0
+		for {
+			doc := map[string]interface{}{}
+			err := iter.Next(ctx, doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return deleted, fmt.Errorf("failed to read documents: %v", err)
+			}
+			actions.Delete(map[string]interface{}{"id": doc["id"]})
+			n++
+		}
+		iter.Stop()
+		if n == 0 {
+			return deleted, nil
+		}
+		if err := actions.Do(ctx); err != nil {
+			return deleted, fmt.Errorf("failed to delete documents: %v", err)
+		}
+		deleted += int64(n)
+	}
+}
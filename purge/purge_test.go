@@ -0,0 +1,104 @@
+package purge
+
+import (
+	"context"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+)
+
+func TestDeleteDocuments(t *testing.T) {
+	tests := []struct {
+		name      string
+		jobId     string
+		batchSize int
+		docs      []string
+		rejected  []string
+		// kept are the documents left in either collection.
+		kept    []string
+		deleted int64
+	}{
+		{
+			name:      "in batches",
+			jobId:     "a",
+			batchSize: 2,
+			docs:      []string{job.RecordID("a", 1), job.RecordID("a", 2), job.RecordID("a", 3), job.RecordID("a", 4), job.RecordID("a", 5)},
+			rejected:  []string{job.RecordID("a", 6)},
+			deleted:   6,
+		},
+		{
+			// Ids sorting right before and after the range of the job.
+			name:      "neighbouring jobs",
+			jobId:     "a",
+			batchSize: 10,
+			docs:      []string{"a", job.RecordID("a", 1), job.RecordID("ab", 1), job.RecordID("b", 1), "a.1", "a,1"},
+			rejected:  []string{job.RecordID("a", 2), job.RecordID("ab", 2)},
+			kept:      []string{"a", "a,1", "a.1", job.RecordID("ab", 1), job.RecordID("ab", 2), job.RecordID("b", 1)},
+			deleted:   2,
+		},
+		{
+			name:      "no documents",
+			jobId:     "a",
+			batchSize: 2,
+			docs:      []string{job.RecordID("b", 1)},
+			kept:      []string{job.RecordID("b", 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			open := func(url string, ids []string) *docstore.Collection {
+				coll, err := docstore.OpenCollection(ctx, url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { coll.Close() })
+				for _, id := range ids {
+					if err := coll.Put(ctx, map[string]interface{}{"id": id}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return coll
+			}
+			coll, quarantine := open("mem://docs/id", tt.docs), open("mem://quarantine/id", tt.rejected)
+			p := New(nil, nil, nil, coll, quarantine, tt.batchSize)
+
+			deleted, err := p.DeleteDocuments(ctx, tt.jobId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.deleted {
+				t.Fatalf("%d deleted, want %d", deleted, tt.deleted)
+			}
+			var kept []string
+			for _, c := range []*docstore.Collection{coll, quarantine} {
+				iter := c.Query().Get(ctx)
+				for {
+					doc := map[string]interface{}{}
+					err := iter.Next(ctx, doc)
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					kept = append(kept, doc["id"].(string))
+				}
+				iter.Stop()
+			}
+			sort.Strings(kept)
+			if len(kept) != len(tt.kept) {
+				t.Fatalf("kept %v, want %v", kept, tt.kept)
+			}
+			for i := range kept {
+				if kept[i] != tt.kept[i] {
+					t.Fatalf("kept %v, want %v", kept, tt.kept)
+				}
+			}
+		})
+	}
+}
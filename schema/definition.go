@@ -0,0 +1,165 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type ErrorMode string
+
+const (
+	// OnErrorReject drops rows failing validation, recording the error on the
+	// job.
+	OnErrorReject ErrorMode = "reject"
+	// OnErrorQuarantine also keeps the raw values of those rows aside.
+	OnErrorQuarantine ErrorMode = "quarantine"
+)
+
+type Field struct {
+	// Name is the column name in the file header.
+	Name     string `docstore:"name" json:"name"`
+	Type     Type   `docstore:"type" json:"type"`
+	Required bool   `docstore:"required" json:"required,omitempty"`
+	// Rename is the field name rows are stored under, defaults to Name.
+	Rename string `docstore:"rename" json:"rename,omitempty"`
+}
+
+// Definition is a schema declared by users for their uploads. Columns not
+// declared are dropped unless KeepUndeclared is set, in which case they are
+// stored with their inferred type.
+type Definition struct {
+	Name           string    `docstore:"name" json:"name,omitempty"`
+	Fields         []Field   `docstore:"fields" json:"fields"`
+	KeepUndeclared bool      `docstore:"keepUndeclared" json:"keepUndeclared,omitempty"`
+	OnError        ErrorMode `docstore:"onError" json:"onError,omitempty"`
+	CreatedAt      time.Time `docstore:"createdAt" json:"createdAt,omitempty"`
+}
+
+// NormalizeName cleans up a header name the way columns are stored.
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.ReplaceAll(name, "\"", "")))
+}
+
+// reservedNames are the document fields the worker sets itself, which
+// columns can not be stored as.
+var reservedNames = map[string]bool{
+	"id":      true,
+	"jobId":   true,
+	"ownerId": true,
+}
+
+var validTypes = map[Type]bool{
+	TypeBool:      true,
+	TypeInt:       true,
+	TypeFloat:     true,
+	TypeTimestamp: true,
+	TypeString:    true,
+}
+
+// Validate checks the definition and normalizes its names and defaults.
+func (d *Definition) Validate() error {
+	if len(d.Fields) == 0 {
+		return errors.New("schema has no fields")
+	}
+	if d.OnError == "" {
+		d.OnError = OnErrorReject
+	}
+	if d.OnError != OnErrorReject && d.OnError != OnErrorQuarantine {
+		return fmt.Errorf("invalid onError %q", d.OnError)
+	}
+	names := map[string]bool{}
+	targets := map[string]bool{}
+	for i := range d.Fields {
+		f := &d.Fields[i]
+		f.Name = NormalizeName(f.Name)
+		if f.Name == "" {
+			return fmt.Errorf("field %d has no name", i)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("field %q declared twice", f.Name)
+		}
+		names[f.Name] = true
+		if !validTypes[f.Type] {
+			return fmt.Errorf("field %q has invalid type %q", f.Name, f.Type)
+		}
+		if f.Rename == "" {
+			f.Rename = f.Name
+		}
+		if reservedNames[f.Rename] {
+			return fmt.Errorf("field %q can not be stored as reserved name %q", f.Name, f.Rename)
+		}
+		if targets[f.Rename] {
+			return fmt.Errorf("more than one field stored as %q", f.Rename)
+		}
+		targets[f.Rename] = true
+	}
+	return nil
+}
+
+// Mapping tells how the column at the same position in a file is stored.
+type Mapping struct {
+	Name     string
+	Type     Type
+	Source   string
+	Required bool
+	Skip     bool
+}
+
+// Plan maps every header column to how it is stored, following def when
+// given and the inferred columns otherwise. It fails when a required column
+// is missing from the header, or when a column would be stored as a reserved
+// name, which a definition can rename it from.
+func Plan(header []string, inferred []Column, def *Definition) ([]Mapping, error) {
+	mappings := make([]Mapping, len(header))
+	if def == nil {
+		for i, c := range inferred {
+			mappings[i] = Mapping{Name: c.Name, Type: c.Type}
+		}
+		return mappings, checkReserved(header, mappings)
+	}
+	declared := map[string]Field{}
+	for _, f := range def.Fields {
+		declared[f.Name] = f
+	}
+	seen := map[string]bool{}
+	for i, name := range header {
+		f, ok := declared[name]
+		switch {
+		case ok:
+			mappings[i] = Mapping{Name: f.Rename, Type: f.Type, Required: f.Required}
+			seen[name] = true
+		case def.KeepUndeclared && i < len(inferred):
+			mappings[i] = Mapping{Name: name, Type: inferred[i].Type}
+		default:
+			mappings[i] = Mapping{Skip: true}
+		}
+	}
+	for _, f := range def.Fields {
+		if f.Required && !seen[f.Name] {
+			return nil, fmt.Errorf("required column %q is missing", f.Name)
+		}
+	}
+	return mappings, checkReserved(header, mappings)
+}
+
+func checkReserved(header []string, mappings []Mapping) error {
+	for i, m := range mappings {
+		if !m.Skip && reservedNames[m.Name] {
+			return fmt.Errorf("column %q can not be stored as reserved name %q, rename it with a schema definition", header[i], m.Name)
+		}
+	}
+	return nil
+}
+
+// Columns lists the stored columns of a plan.
+func Columns(mappings []Mapping) []Column {
+	columns := make([]Column, 0, len(mappings))
+	for _, m := range mappings {
+		if !m.Skip {
+			columns = append(columns, Column{Name: m.Name, Type: m.Type, Source: m.Source})
+		}
+	}
+	return columns
+}
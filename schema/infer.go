@@ -0,0 +1,29 @@
+package schema
+
+// Inferrer finds the type of every column by observing all of its values.
+type Inferrer struct {
+	columns []Column
+}
+
+func NewInferrer(names []string) *Inferrer {
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i] = Column{Name: name, Type: TypeNull}
+	}
+	return &Inferrer{columns: columns}
+}
+
+func (inf *Inferrer) Observe(values []string) {
+	for i, v := range values {
+		if i >= len(inf.columns) {
+			return
+		}
+		inf.columns[i].Type = Merge(inf.columns[i].Type, Detect(v))
+	}
+}
+
+func (inf *Inferrer) Columns() []Column {
+	columns := make([]Column, len(inf.columns))
+	copy(columns, inf.columns)
+	return columns
+}
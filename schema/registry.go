@@ -0,0 +1,70 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+var ErrNotFound = errors.New("schema not found")
+
+// Registry stores named schema definitions, keyed by name.
+type Registry struct {
+	coll *docstore.Collection
+}
+
+func NewRegistry(coll *docstore.Collection) *Registry {
+	return &Registry{coll: coll}
+}
+
+func (r *Registry) Close() error {
+	return r.coll.Close()
+}
+
+// Put validates and stores the definition, replacing any with the same name.
+func (r *Registry) Put(ctx context.Context, d *Definition) error {
+	if d.Name == "" {
+		return errors.New("schema has no name")
+	}
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	d.CreatedAt = time.Now().UTC()
+	if err := r.coll.Put(ctx, d); err != nil {
+		return fmt.Errorf("failed to save schema: %v", err)
+	}
+	return nil
+}
+
+func (r *Registry) Get(ctx context.Context, name string) (*Definition, error) {
+	d := &Definition{Name: name}
+	if err := r.coll.Get(ctx, d); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get schema: %v", err)
+	}
+	return d, nil
+}
+
+func (r *Registry) List(ctx context.Context) ([]*Definition, error) {
+	iter := r.coll.Query().Get(ctx)
+	defer iter.Stop()
+	list := []*Definition{}
+	for {
+		d := &Definition{}
+		err := iter.Next(ctx, d)
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list schemas: %v", err)
+		}
+		list = append(list, d)
+	}
+}
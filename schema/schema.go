@@ -0,0 +1,128 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeNull      Type = "null"
+	TypeBool      Type = "bool"
+	TypeInt       Type = "int"
+	TypeFloat     Type = "float"
+	TypeTimestamp Type = "timestamp"
+	TypeString    Type = "string"
+)
+
+type Column struct {
+	Name string `docstore:"name" json:"name"`
+	Type Type   `docstore:"type" json:"type"`
+	// Source is the column name as found in the file header.
+	Source string `docstore:"source,omitempty" json:"source,omitempty"`
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func IsNull(v string) bool {
+	return v == "" || strings.EqualFold(v, "null")
+}
+
+// Detect returns the narrowest type able to represent v.
+func Detect(v string) Type {
+	if IsNull(v) {
+		return TypeNull
+	}
+	if strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
+		return TypeBool
+	}
+	if hasLeadingZero(v) {
+		// Codes like zip codes would lose their zeros as numbers.
+		return TypeString
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return TypeInt
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return TypeFloat
+	}
+	if _, err := parseTimestamp(v); err == nil {
+		return TypeTimestamp
+	}
+	return TypeString
+}
+
+func hasLeadingZero(v string) bool {
+	v = strings.TrimLeft(v, "+-")
+	return len(v) > 1 && v[0] == '0' && v[1] != '.'
+}
+
+// Merge returns the type able to hold values of both a and b.
+func Merge(a, b Type) Type {
+	switch {
+	case a == b:
+		return a
+	case a == TypeNull:
+		return b
+	case b == TypeNull:
+		return a
+	case (a == TypeInt && b == TypeFloat) || (a == TypeFloat && b == TypeInt):
+		return TypeFloat
+	}
+	return TypeString
+}
+
+func parseTimestamp(v string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %q", v)
+}
+
+// Convert parses v as a value of type t. Null values convert to nil for
+// every type.
+func Convert(t Type, v string) (interface{}, error) {
+	if IsNull(v) {
+		return nil, nil
+	}
+	switch t {
+	case TypeNull:
+		return nil, fmt.Errorf("expected null, got %q", v)
+	case TypeBool:
+		if strings.EqualFold(v, "true") {
+			return true, nil
+		}
+		if strings.EqualFold(v, "false") {
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid bool: %q", v)
+	case TypeInt:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int: %q", v)
+		}
+		return n, nil
+	case TypeFloat:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float: %q", v)
+		}
+		return f, nil
+	case TypeTimestamp:
+		return parseTimestamp(v)
+	case TypeString:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", t)
+}
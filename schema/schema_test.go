@@ -0,0 +1,66 @@
+package schema
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Type
+	}{
+		{"", TypeNull},
+		{"NULL", TypeNull},
+		{"true", TypeBool},
+		{"False", TypeBool},
+		{"42", TypeInt},
+		{"-7", TypeInt},
+		{"0", TypeInt},
+		{"3.14", TypeFloat},
+		{"0.5", TypeFloat},
+		{"1e3", TypeFloat},
+		{"NaN", TypeString},
+		{"Inf", TypeString},
+		{"02134", TypeString},
+		{"-007", TypeString},
+		{"99999999999999999999", TypeFloat},
+		{"2021-06-01", TypeTimestamp},
+		{"2021-06-01 10:30:00", TypeTimestamp},
+		{"2021-06-01T10:30:00Z", TypeTimestamp},
+		{"06/01/2021", TypeString},
+		{"Boston", TypeString},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := Detect(tt.value); got != tt.want {
+				t.Fatalf("Detect(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		a, b Type
+		want Type
+	}{
+		{TypeInt, TypeInt, TypeInt},
+		{TypeNull, TypeInt, TypeInt},
+		{TypeTimestamp, TypeNull, TypeTimestamp},
+		{TypeNull, TypeNull, TypeNull},
+		{TypeInt, TypeFloat, TypeFloat},
+		{TypeFloat, TypeInt, TypeFloat},
+		{TypeBool, TypeInt, TypeString},
+		{TypeTimestamp, TypeFloat, TypeString},
+		{TypeString, TypeBool, TypeString},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.a)+"+"+string(tt.b), func(t *testing.T) {
+			if got := Merge(tt.a, tt.b); got != tt.want {
+				t.Fatalf("Merge(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+			}
+			// The order of the values does not matter.
+			if got := Merge(tt.b, tt.a); got != tt.want {
+				t.Fatalf("Merge(%s, %s) = %s, want %s", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,11 +2,19 @@ package telemetry
 
 import (
 	"context"
+	"fmt"
 
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
 	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 )
 
+var (
+	tracerProvider    *sdktrace.TracerProvider
+	metricsController *controller.Controller
+)
+
 func GetResource(serviceName string) (*resource.Resource, error) {
 	ctx := context.Background()
 	return resource.New(
@@ -14,3 +22,19 @@ func GetResource(serviceName string) (*resource.Resource, error) {
 		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
 	)
 }
+
+// Shutdown flushes and stops the tracer and meter providers set up by
+// InitTracing and InitMetrics.
+func Shutdown(ctx context.Context) error {
+	if tracerProvider != nil {
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shutdown tracer provider: %v", err)
+		}
+	}
+	if metricsController != nil {
+		if err := metricsController.Stop(ctx); err != nil {
+			return fmt.Errorf("failed to stop metrics controller: %v", err)
+		}
+	}
+	return nil
+}
@@ -28,6 +28,7 @@ func InitTracing(serviceName string) error {
 		sdktrace.WithResource(res),
 	)
 
+	tracerProvider = tp
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 	return nil
@@ -51,6 +51,7 @@ func InitMetrics(serviceName string) error {
 		}
 	}()
 
+	metricsController = c
 	global.SetMeterProvider(metricsExporter.MeterProvider())
 	if err := runtime.Start(); err != nil {
 		log.Fatalf("failed to setup runtime monitor: %v", err)
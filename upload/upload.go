@@ -0,0 +1,186 @@
+// Package upload keeps track of files uploaded in chunks, which can be
+// resumed from the last chunk stored after a failure.
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"github.com/google/uuid"
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+var (
+	ErrNotFound = errors.New("upload not found")
+	// ErrOffsetMismatch is returned when a chunk does not start where the
+	// upload ends, as when it was already stored by a retried request.
+	ErrOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+	ErrConflict       = errors.New("upload was modified concurrently")
+)
+
+type State string
+
+const (
+	StateUploading State = "uploading"
+	// StateCompleted uploads were turned into a job, of the same id.
+	StateCompleted State = "completed"
+)
+
+// Part is a chunk of the file, stored in the bucket as received.
+type Part struct {
+	Key    string `docstore:"key" json:"key"`
+	Offset int64  `docstore:"offset" json:"offset"`
+	Size   int64  `docstore:"size" json:"size"`
+}
+
+// Upload is a file being uploaded in chunks. It carries the options of the
+// job created from it once all of its Length bytes are received.
+type Upload struct {
+	ID       string `docstore:"id" json:"id"`
+	Filename string `docstore:"filename" json:"filename"`
+	Uploader string `docstore:"uploader" json:"uploader"`
+	// Owner is the id of the API key that started the upload.
+	Owner  string `docstore:"owner" json:"owner,omitempty"`
+	Length int64  `docstore:"length" json:"length"`
+	Offset int64  `docstore:"offset" json:"offset"`
+	Parts  []Part `docstore:"parts" json:"-"`
+	State  State  `docstore:"state" json:"state"`
+	JobID  string `docstore:"jobId" json:"jobId,omitempty"`
+	// Direct uploads are written by the client straight to the bucket,
+	// through a signed URL, rather than in chunks through the API. Their
+	// length is only known once finalized.
+	Direct    bool      `docstore:"direct" json:"direct,omitempty"`
+	CreatedAt time.Time `docstore:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `docstore:"updatedAt" json:"updatedAt"`
+	// ExpiresAt is given to the job created from the upload.
+	ExpiresAt time.Time `docstore:"expiresAt" json:"expiresAt,omitempty"`
+
+	Parse parser.Options `docstore:"parse" json:"parse"`
+	// Scan checks every row of the file when finalized, not only its header.
+	Scan             bool               `docstore:"scan" json:"scan,omitempty"`
+	SchemaDefinition *schema.Definition `docstore:"schemaDefinition" json:"schemaDefinition,omitempty"`
+	// OnDuplicate tells what finalizing does when the owner already uploaded
+	// the same content, like the form field of direct uploads.
+	OnDuplicate string `docstore:"onDuplicate" json:"onDuplicate,omitempty"`
+
+	DocstoreRevision interface{} `json:"-"`
+}
+
+// PartKey is where a chunk starting at offset is stored in the bucket. Keys
+// are unique so a chunk losing a race with a concurrent one can be removed
+// without touching the other.
+func PartKey(id string, offset int64) string {
+	return fmt.Sprintf("uploads/%s/%020d-%s", id, offset, uuid.NewString())
+}
+
+// Complete tells whether every byte of the file was received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Append records a chunk stored at the end of the upload.
+func (u *Upload) Append(p Part) error {
+	if u.State != StateUploading {
+		return fmt.Errorf("upload is %s", u.State)
+	}
+	if u.Direct {
+		return errors.New("upload is written through its signed URL")
+	}
+	if p.Offset != u.Offset {
+		return fmt.Errorf("%w: upload is at %d, chunk starts at %d", ErrOffsetMismatch, u.Offset, p.Offset)
+	}
+	u.Parts = append(u.Parts, p)
+	u.Offset += p.Size
+	return nil
+}
+
+type Store struct {
+	coll *docstore.Collection
+}
+
+func NewStore(coll *docstore.Collection) *Store {
+	return &Store{coll: coll}
+}
+
+func (s *Store) Close() error {
+	return s.coll.Close()
+}
+
+func (s *Store) Create(ctx context.Context, u *Upload) error {
+	now := time.Now().UTC()
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	if u.State == "" {
+		u.State = StateUploading
+	}
+	if err := s.coll.Create(ctx, u); err != nil {
+		return fmt.Errorf("failed to create upload: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*Upload, error) {
+	u := &Upload{ID: id}
+	if err := s.coll.Get(ctx, u); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get upload: %v", err)
+	}
+	return u, nil
+}
+
+// Update reads the upload, applies fn and writes it back. The write is
+// rejected if the upload was modified concurrently.
+func (s *Store) Update(ctx context.Context, id string, fn func(u *Upload) error) (*Upload, error) {
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(u); err != nil {
+		return u, err
+	}
+	u.UpdatedAt = time.Now().UTC()
+	if err := s.coll.Replace(ctx, u); err != nil {
+		if gcerrors.Code(err) == gcerrors.FailedPrecondition {
+			return nil, ErrConflict
+		}
+		return nil, fmt.Errorf("failed to update upload: %v", err)
+	}
+	return u, nil
+}
+
+// Each calls fn with every upload, stopping at the first error returned.
+func (s *Store) Each(ctx context.Context, fn func(u *Upload) error) error {
+	iter := s.coll.Query().Get(ctx)
+	defer iter.Stop()
+	for {
+		u := &Upload{}
+		err := iter.Next(ctx, u)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list uploads: %v", err)
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if err := s.coll.Delete(ctx, &Upload{ID: id}); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	return nil
+}
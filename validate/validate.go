@@ -0,0 +1,277 @@
+// Package validate checks files against the limits of the service before
+// they are ingested, reporting every problem found.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+)
+
+// maxProblems caps the problems listed in a report, the scan of a file stops
+// once it is reached.
+const maxProblems = 100
+
+// Limits bound the files accepted on upload. A zero limit is not enforced.
+type Limits struct {
+	// MaxFileSize is the size of the file as uploaded, before it is
+	// decompressed.
+	MaxFileSize int64
+	MaxRows     int
+	MaxColumns  int
+	// HeaderChecks rejects files with blank or duplicated column names,
+	// compared the way the worker names fields.
+	HeaderChecks bool
+	// Scan reads every row of uploaded files to find malformed ones, rather
+	// than only their header. Uploads can also ask for it with the scan
+	// form field.
+	Scan bool
+}
+
+// Problem is a reason a file was rejected. Line is set for problems with a
+// single row and Column, counted from 1, for problems with a column of the
+// header.
+type Problem struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Sheet   string `json:"sheet,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// Report lists the problems of a rejected file, as sent by the API with a
+// 422. Rows is only counted when every row was read.
+type Report struct {
+	Error     string    `json:"error"`
+	Size      int64     `json:"size"`
+	Columns   int       `json:"columns"`
+	Rows      int       `json:"rows,omitempty"`
+	Scanned   bool      `json:"scanned"`
+	Problems  []Problem `json:"problems"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// Error is returned for files with problems, carrying their report.
+type Error struct {
+	Report *Report
+}
+
+func (e *Error) Error() string {
+	return e.Report.Error
+}
+
+// validator checks a file against the limits, collecting every problem
+// found rather than stopping at the first one.
+type validator struct {
+	limits Limits
+	scan   bool
+	report Report
+}
+
+func newValidator(limits Limits, scan bool, size int64) *validator {
+	v := &validator{limits: limits, scan: scan}
+	v.report.Size = size
+	v.report.Scanned = scan
+	if limits.MaxFileSize > 0 && size > limits.MaxFileSize {
+		v.add(Problem{
+			Code:    "file_too_large",
+			Message: fmt.Sprintf("file is %d bytes, the limit is %d", size, limits.MaxFileSize),
+		})
+	}
+	return v
+}
+
+func (v *validator) add(p Problem) {
+	if len(v.report.Problems) >= maxProblems {
+		v.report.Truncated = true
+		return
+	}
+	v.report.Problems = append(v.report.Problems, p)
+}
+
+// check validates the header of p and, when scanning or counting rows, every
+// row after it. sheet names the sheet p reads, if any.
+func (v *validator) check(sheet string, p parser.Reader) {
+	header := p.Header()
+	if len(header) > v.report.Columns {
+		v.report.Columns = len(header)
+	}
+	if v.limits.MaxColumns > 0 && len(header) > v.limits.MaxColumns {
+		v.add(Problem{
+			Code:    "too_many_columns",
+			Message: fmt.Sprintf("header has %d columns, the limit is %d", len(header), v.limits.MaxColumns),
+			Sheet:   sheet,
+		})
+	}
+	if v.limits.HeaderChecks {
+		seen := make(map[string]int, len(header))
+		for i, name := range header {
+			field := schema.NormalizeName(name)
+			if field == "" {
+				v.add(Problem{
+					Code:    "blank_column_name",
+					Message: fmt.Sprintf("column %d has no name", i+1),
+					Sheet:   sheet,
+					Column:  i + 1,
+				})
+				continue
+			}
+			if first, ok := seen[field]; ok {
+				v.add(Problem{
+					Code:    "duplicate_column_name",
+					Message: fmt.Sprintf("column %d is named %q like column %d", i+1, name, first),
+					Sheet:   sheet,
+					Column:  i + 1,
+					Name:    name,
+				})
+				continue
+			}
+			seen[field] = i + 1
+		}
+	}
+
+	if !v.scan && (v.limits.MaxRows == 0 || v.report.Rows > v.limits.MaxRows) {
+		return
+	}
+	rows := 0
+	for !v.report.Truncated {
+		_, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		var le *parser.LineError
+		if errors.As(err, &le) {
+			if v.scan {
+				v.add(Problem{Code: "invalid_row", Message: le.Err.Error(), Sheet: sheet, Line: le.Line})
+			}
+		} else if err != nil {
+			// Nothing after a malformed file structure can be read.
+			v.add(Problem{Code: "invalid_file", Message: err.Error(), Sheet: sheet, Line: p.Line()})
+			break
+		}
+		rows++
+		if v.limits.MaxRows > 0 && v.report.Rows+rows == v.limits.MaxRows+1 {
+			v.add(Problem{
+				Code:    "too_many_rows",
+				Message: fmt.Sprintf("file has more than %d rows", v.limits.MaxRows),
+				Sheet:   sheet,
+				Line:    p.Line(),
+			})
+			if !v.scan {
+				break
+			}
+		}
+	}
+	v.report.Rows += rows
+}
+
+// invalidFile records a file, or sheet, whose structure can not be read.
+func (v *validator) invalidFile(sheet string, err error) {
+	v.add(Problem{Code: "invalid_file", Message: err.Error(), Sheet: sheet})
+}
+
+// err returns an *Error carrying the report when any problem was found.
+func (v *validator) err() error {
+	n := len(v.report.Problems)
+	if n == 0 {
+		return nil
+	}
+	v.report.Error = "file is invalid: " + v.report.Problems[0].Message
+	if n > 1 {
+		v.report.Error += fmt.Sprintf(" (and %d more problems)", n-1)
+	}
+	if v.limits.MaxRows > 0 && !v.scan {
+		// Reading stopped at the limit, the count is not the file's.
+		v.report.Rows = 0
+	}
+	return &Error{Report: &v.report}
+}
+
+// File reads the header of a file to make sure the worker is able to,
+// recording its compression in opts, and checks it against the limits. With
+// scan, or a row limit, every row is read too. The files held by an archive
+// are checked by the worker as it turns them into jobs of their own.
+// Problems with the file, including a structure that can not be read, are
+// returned as an *Error. Other errors come from reading ra.
+func File(ra io.ReaderAt, size int64, opts *parser.Options, limits Limits, scan bool) error {
+	v := newValidator(limits, scan, size)
+	if err := v.err(); err != nil {
+		return err
+	}
+	rr := &readerAt{ra: ra}
+	if opts.Format == parser.FormatXLSX {
+		checkWorkbook(rr, size, opts.Sheet, v)
+	} else {
+		checkContent(rr, size, opts, v)
+	}
+	if rr.err != nil {
+		return rr.err
+	}
+	return v.err()
+}
+
+// checkContent reads the file, decompressed, unless it is an archive.
+func checkContent(ra io.ReaderAt, size int64, opts *parser.Options, v *validator) {
+	content, compression, err := parser.Decompress(io.NewSectionReader(ra, 0, size))
+	opts.Compression = compression
+	if err == parser.ErrArchive {
+		return
+	}
+	if err != nil {
+		v.invalidFile("", err)
+		return
+	}
+	defer content.Close()
+	p, err := parser.NewReader(content, *opts)
+	if err != nil {
+		v.invalidFile("", err)
+		return
+	}
+	v.check("", p)
+}
+
+// checkWorkbook reads the chosen sheet, or every sheet when each is ingested
+// as a job of its own.
+func checkWorkbook(ra io.ReaderAt, size int64, sheet string, v *validator) {
+	wb, err := parser.OpenWorkbook(ra, size)
+	if err != nil {
+		v.invalidFile("", err)
+		return
+	}
+	sheets := []string{sheet}
+	if sheet == parser.AllSheets {
+		sheets = wb.Sheets()
+		if len(sheets) == 0 {
+			v.invalidFile("", errors.New("workbook has no sheets"))
+			return
+		}
+	}
+	for _, name := range sheets {
+		sr, err := wb.NewReader(name)
+		if err != nil {
+			v.invalidFile(name, err)
+			continue
+		}
+		v.check(name, sr)
+		sr.Close()
+	}
+}
+
+// readerAt keeps the first error of reading the file, which is not a
+// problem with the file itself.
+type readerAt struct {
+	ra  io.ReaderAt
+	err error
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ra.ReadAt(p, off)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
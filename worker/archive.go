@@ -0,0 +1,316 @@
+package worker
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/cloud"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"github.com/alvarowolfx/cloud-native-go/validate"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+)
+
+// expandArchive stores every file of the zip archive of j as a child job of
+// its own and queues it. Children are recorded on the archive job as they are
+// queued, so a redelivered message only queues the ones left.
+func (w *worker) expandArchive(ctx context.Context, j *job.Job) error {
+	ra, err := cloud.NewBlobReaderAt(ctx, w.bucket, j.FileKey())
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return permanent(fmt.Errorf("file not found: %v", err))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		return permanent(fmt.Errorf("invalid zip file: %v", err))
+	}
+	if err := w.checkArchive(ctx, j, zr); err != nil {
+		return err
+	}
+
+	queued := make(map[string]bool, len(j.Children))
+	for _, id := range j.Children {
+		queued[id] = true
+	}
+	for i, f := range zr.File {
+		if !archiveEntry(f) {
+			continue
+		}
+		childId := childID(j, strconv.Itoa(i))
+		if queued[childId] {
+			continue
+		}
+		f := f
+		j, err = w.queueChild(ctx, j, childId, func() (*job.Job, error) {
+			return w.createArchiveChild(ctx, j, f, childId)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to expand %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// expandWorkbook queues a child job for every sheet of the workbook of j,
+// each reading the file of j. Deleting j deletes its children before the
+// file.
+func (w *worker) expandWorkbook(ctx context.Context, j *job.Job) error {
+	ra, err := cloud.NewBlobReaderAt(ctx, w.bucket, j.FileKey())
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return permanent(fmt.Errorf("file not found: %v", err))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	wb, err := parser.OpenWorkbook(ra, ra.Size())
+	if err != nil {
+		return permanent(err)
+	}
+
+	queued := make(map[string]bool, len(j.Children))
+	for _, id := range j.Children {
+		queued[id] = true
+	}
+	for _, sheet := range wb.Sheets() {
+		childId := childID(j, "sheet/"+sheet)
+		if queued[childId] {
+			continue
+		}
+		sheet := sheet
+		j, err = w.queueChild(ctx, j, childId, func() (*job.Job, error) {
+			child := &job.Job{
+				ID:       childId,
+				Filename: j.Filename + "/" + sheet,
+				Size:     ra.Size(),
+				Uploader: j.Uploader,
+				Owner:    j.Owner,
+				State:    job.StateUploaded,
+
+				ExpiresAt:        j.ExpiresAt,
+				SchemaDefinition: j.SchemaDefinition,
+				Parse:            parser.Options{Format: parser.FormatXLSX, Sheet: sheet},
+				Source:           j.FileKey(),
+				ParentID:         j.ID,
+			}
+			return child, w.jobs.Create(ctx, child)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to expand sheet %s: %w", sheet, err)
+		}
+	}
+	return nil
+}
+
+// checkArchive fails an archive holding more files than ArchiveMaxEntries,
+// or held by more archives than ArchiveMaxDepth.
+func (w *worker) checkArchive(ctx context.Context, j *job.Job, zr *zip.Reader) error {
+	if max := w.opts.ArchiveMaxEntries; max > 0 {
+		entries := 0
+		for _, f := range zr.File {
+			if archiveEntry(f) {
+				entries++
+			}
+		}
+		if entries > max {
+			return permanent(fmt.Errorf("archive has %d files, the limit is %d", entries, max))
+		}
+	}
+	if max := w.opts.ArchiveMaxDepth; max > 0 {
+		depth := 0
+		for parentId := j.ParentID; parentId != ""; depth++ {
+			if depth >= max {
+				return permanent(fmt.Errorf("archive is nested in more than %d archives", max))
+			}
+			parent, err := w.jobs.Get(ctx, parentId)
+			if errors.Is(err, job.ErrNotFound) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			parentId = parent.ParentID
+		}
+	}
+	return nil
+}
+
+// childID derives the id of a child job from its place in the parent file,
+// so a redelivered message finds the children it already created.
+func childID(parent *job.Job, part string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(parent.ID+"/"+part)).String()
+}
+
+// archiveEntry tells whether f is a data file, leaving out directories and
+// the hidden files some archivers add.
+func archiveEntry(f *zip.File) bool {
+	if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+		return false
+	}
+	return !strings.HasPrefix(path.Base(f.Name), ".")
+}
+
+// queueChild queues the child job childId, created with create unless a
+// previous attempt did, and records it on the parent job, which it returns.
+func (w *worker) queueChild(ctx context.Context, parent *job.Job, childId string, create func() (*job.Job, error)) (*job.Job, error) {
+	child, err := w.jobs.Get(ctx, childId)
+	if errors.Is(err, job.ErrNotFound) {
+		child, err = create()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A child past uploaded was queued by a previous attempt that failed
+	// before recording it.
+	if child.State == job.StateUploaded {
+		if err := w.queueJob(ctx, childId); err != nil {
+			return nil, err
+		}
+	}
+	return w.jobs.Update(ctx, parent.ID, func(current *job.Job) error {
+		current.Children = append(current.Children, childId)
+		return nil
+	})
+}
+
+func (w *worker) queueJob(ctx context.Context, jobId string) error {
+	if _, err := w.jobs.Transition(ctx, jobId, job.StateQueued, ""); err != nil {
+		return err
+	}
+	msg := &pubsub.Message{
+		Body: []byte(jobId),
+		Metadata: map[string]string{
+			"eventType": "file.upload",
+		},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
+	if err := w.topic.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to queue job %s: %v", jobId, err)
+	}
+	return nil
+}
+
+// createArchiveChild copies the file out of the archive and registers its
+// job, read like the archive unless its name tells another format. A file
+// that can not be extracted or is rejected like an upload is kept as a
+// failed job, for the rest of the archive to go on and its error to be seen.
+func (w *worker) createArchiveChild(ctx context.Context, parent *job.Job, f *zip.File, childId string) (*job.Job, error) {
+	opts := parser.Options{Format: parent.Parse.Format}
+	if format, ok := parser.FormatOf(f.Name); ok {
+		opts.Format = format
+	}
+	if opts.Format == parent.Parse.Format {
+		opts.Delimiter = parent.Parse.Delimiter
+		opts.Sheet = parent.Parse.Sheet
+	}
+	child := &job.Job{
+		ID:       childId,
+		Filename: f.Name,
+		Size:     int64(f.UncompressedSize64),
+		Uploader: parent.Uploader,
+		Owner:    parent.Owner,
+		State:    job.StateUploaded,
+
+		ExpiresAt:        parent.ExpiresAt,
+		SchemaDefinition: parent.SchemaDefinition,
+		Parse:            opts,
+		ParentID:         parent.ID,
+	}
+
+	size, err := w.extractArchiveEntry(ctx, f, childId)
+	if isPermanent(err) {
+		failChild(child, err)
+		return child, w.jobs.Create(ctx, child)
+	}
+	if err != nil {
+		return nil, err
+	}
+	child.Size = size
+
+	// The file is checked like an upload, recording its compression.
+	ra, err := cloud.NewBlobReaderAt(ctx, w.bucket, childId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	err = validate.File(ra, size, &child.Parse, w.opts.Limits, w.opts.Limits.Scan)
+	var ve *validate.Error
+	if errors.As(err, &ve) {
+		failChild(child, ve)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if err := w.jobs.Create(ctx, child); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// extractArchiveEntry copies f to key, returning its size. Entries larger
+// than ArchiveMaxEntrySize or that are not valid fail permanently, leaving
+// nothing at key.
+func (w *worker) extractArchiveEntry(ctx context.Context, f *zip.File, key string) (int64, error) {
+	max := w.opts.ArchiveMaxEntrySize
+	if max > 0 && f.UncompressedSize64 > uint64(max) {
+		return 0, permanent(archiveEntryTooLarge(f, max))
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return 0, permanent(fmt.Errorf("invalid zip entry: %v", err))
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if max > 0 {
+		// The size in the header is not checked until the end of the file,
+		// so the copy stops one byte past the limit.
+		r = io.LimitReader(rc, max+1)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bw, err := w.bucket.NewWriter(ctx, key, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save file: %v", err)
+	}
+	size, err := io.Copy(bw, r)
+	if err == nil && max > 0 && size > max {
+		err = permanent(archiveEntryTooLarge(f, max))
+	}
+	if err != nil {
+		// Cancelling the context before Close discards the partial file.
+		cancel()
+		bw.Close()
+		if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) {
+			return 0, permanent(fmt.Errorf("invalid zip entry: %v", err))
+		}
+		if isPermanent(err) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to save file: %v", err)
+	}
+	if err := bw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to save file: %v", err)
+	}
+	return size, nil
+}
+
+// failChild marks a child job that is not created to be ingested as failed.
+func failChild(child *job.Job, err error) {
+	child.State = job.StateFailed
+	child.Error = err.Error()
+	child.FinishedAt = time.Now().UTC()
+}
+
+func archiveEntryTooLarge(f *zip.File, max int64) error {
+	return fmt.Errorf("archive file %s is larger than the limit of %d bytes", f.Name, max)
+}
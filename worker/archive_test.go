@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+)
+
+func TestExpandArchive(t *testing.T) {
+	ctx := context.Background()
+	type entry struct {
+		name, content string
+		// state is what the child job of the entry is left in.
+		state job.State
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{
+			name: "valid",
+			entries: []entry{
+				{"a.csv", "a,b\n1,2\n", job.StateQueued},
+				{"b.csv", "c\n3\n", job.StateQueued},
+			},
+		},
+		{
+			name: "entry too large in between",
+			entries: []entry{
+				{"a.csv", "a,b\n1,2\n", job.StateQueued},
+				{"large.csv", "a,b\n" + string(bytes.Repeat([]byte("1,2\n"), 100)), job.StateFailed},
+				{"b.csv", "c\n3\n", job.StateQueued},
+			},
+		},
+		{
+			name: "entry rejected like an upload",
+			entries: []entry{
+				{"empty.csv", "", job.StateFailed},
+				{"b.csv", "c\n3\n", job.StateQueued},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorker(t, Options{ArchiveMaxEntrySize: 100})
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			for _, e := range tt.entries {
+				fw, err := zw.Create(e.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := fw.Write([]byte(e.content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			j := &job.Job{ID: "archive", Owner: "k", State: job.StateProcessing, Parse: parser.Options{Format: parser.FormatCSV}}
+			if err := w.jobs.Create(ctx, j); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.bucket.WriteAll(ctx, j.ID, buf.Bytes(), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.expandArchive(ctx, j); err != nil {
+				t.Fatal(err)
+			}
+			j, err := w.jobs.Get(ctx, j.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(j.Children) != len(tt.entries) {
+				t.Fatalf("%d children, want %d", len(j.Children), len(tt.entries))
+			}
+			for i, e := range tt.entries {
+				child, err := w.jobs.Get(ctx, j.Children[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if child.Filename != e.name || child.State != e.state {
+					t.Fatalf("child %s %s, want %s %s", child.Filename, child.State, e.name, e.state)
+				}
+				if e.state == job.StateFailed && child.Error == "" {
+					t.Fatalf("child %s failed without an error", child.Filename)
+				}
+			}
+		})
+	}
+}
+
+// testWorkbook returns an xlsx file with a sheet of the given name for
+// every header, holding that header and one row.
+func testWorkbook(t *testing.T, sheets map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, content string) {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	book := `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	rels := `<Relationships>`
+	i := 0
+	for name, header := range sheets {
+		i++
+		book += fmt.Sprintf(`<sheet name="%s" r:id="rId%d"/>`, name, i)
+		rels += fmt.Sprintf(`<Relationship Id="rId%d" Target="worksheets/sheet%d.xml"/>`, i, i)
+		add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i), `<worksheet><sheetData>`+
+			`<row r="1"><c r="A1" t="inlineStr"><is><t>`+header+`</t></is></c></row>`+
+			`<row r="2"><c r="A2"><v>1</v></c></row>`+
+			`</sheetData></worksheet>`)
+	}
+	add("xl/workbook.xml", book+`</sheets></workbook>`)
+	add("xl/_rels/workbook.xml.rels", rels+`</Relationships>`)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExpandWorkbook(t *testing.T) {
+	ctx := context.Background()
+	sheets := map[string]string{"homes": "price", "agents": "name"}
+	w := newTestWorker(t, Options{})
+	j := &job.Job{ID: "book", Owner: "k", State: job.StateProcessing, Parse: parser.Options{Format: parser.FormatXLSX, Sheet: parser.AllSheets}}
+	if err := w.jobs.Create(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.bucket.WriteAll(ctx, j.ID, testWorkbook(t, sheets), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.expandWorkbook(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	j, err := w.jobs.Get(ctx, j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Children) != len(sheets) {
+		t.Fatalf("%d children, want %d", len(j.Children), len(sheets))
+	}
+	for _, childId := range j.Children {
+		child, err := w.jobs.Get(ctx, childId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Sheets are read from the workbook rather than a copy of it.
+		if child.FileKey() != j.ID {
+			t.Fatalf("sheet %s read from %s, want %s", child.Parse.Sheet, child.FileKey(), j.ID)
+		}
+		if ok, err := w.bucket.Exists(ctx, childId); err != nil || ok {
+			t.Fatalf("file stored for sheet %s: %v", child.Parse.Sheet, err)
+		}
+		rows, err := w.openRows(ctx, child)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows.header) != 1 || rows.header[0] != sheets[child.Parse.Sheet] {
+			t.Fatalf("sheet %s has header %v, want %s", child.Parse.Sheet, rows.header, sheets[child.Parse.Sheet])
+		}
+		rows.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"go.opentelemetry.io/otel"
+	"gocloud.dev/blob"
+)
+
+// processExport writes the documents of the export job to the bucket. A
+// redelivered message writes the whole file again.
+func (w *worker) processExport(ctx context.Context, exportId string) error {
+	tracer := otel.Tracer("worker")
+
+	e, err := w.exports.Transition(ctx, exportId, job.StateProcessing, "")
+	if errors.Is(err, job.ErrInvalidTransition) || errors.Is(err, export.ErrNotFound) {
+		w.logger.Warnf("skipping export %s: %v", exportId, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	j, err := w.jobs.Get(ctx, e.JobID)
+	if errors.Is(err, job.ErrNotFound) {
+		return permanent(fmt.Errorf("job %s not found", e.JobID))
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, span := tracer.Start(ctx, "export.write")
+	size, rows, err := w.writeExport(ctx, e, j)
+	span.End()
+	if err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	_, err = w.exports.Update(ctx, exportId, func(current *export.Export) error {
+		current.Size = size
+		current.Rows = rows
+		return current.Transition(job.StateCompleted, "")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete export: %w", err)
+	}
+	w.totalExportsWritten.Add(ctx, 1, slotAttr(ctx))
+	return nil
+}
+
+// writeExport streams the documents of j into the export file and returns
+// its size and number of rows. The file is only committed to the bucket when
+// every document was written.
+func (w *worker) writeExport(ctx context.Context, e *export.Export, j *job.Job) (int64, int64, error) {
+	writeCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	bw, err := w.bucket.NewWriter(writeCtx, e.Key, &blob.WriterOptions{
+		ContentType:        e.Format.ContentType(),
+		ContentDisposition: fmt.Sprintf("attachment; filename=%q", export.Filename(j, e.Format)),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	counter := &countingWriter{w: bw}
+	out, err := export.NewWriter(e.Format, counter, j.Schema)
+	var rows int64
+	if err == nil {
+		err = export.Documents(ctx, w.coll, j.ID, func(doc map[string]interface{}) error {
+			rows++
+			return out.Write(doc)
+		})
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		abort()
+		_ = bw.Close()
+		return 0, 0, err
+	}
+	if err := bw.Close(); err != nil {
+		return 0, 0, err
+	}
+	return counter.n, rows, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
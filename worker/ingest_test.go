@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+)
+
+func TestIngestBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		rows      int
+		batchSize int
+		// batches is how many writes the rows are stored in.
+		batches int64
+	}{
+		{"no rows", 0, 2, 0},
+		{"full batches", 4, 2, 2},
+		{"last batch partial", 5, 2, 3},
+		{"one batch", 3, 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			w := newTestWorker(t, Options{BatchSize: tt.batchSize})
+			content := "a,b\n" + strings.Repeat("1,x\n", tt.rows)
+			j := &job.Job{ID: "j", Owner: "k", State: job.StateProcessing, Parse: parser.Options{Format: parser.FormatCSV}}
+			w.storeJob(t, j, content)
+
+			if err := w.ingest(ctx, j); err != nil {
+				t.Fatal(err)
+			}
+			if j.LinesProcessed != int64(tt.rows) {
+				t.Fatalf("%d lines processed, want %d", j.LinesProcessed, tt.rows)
+			}
+			docs := 0
+			iter := w.coll.Query().Where("jobId", "=", j.ID).Get(ctx)
+			defer iter.Stop()
+			for {
+				err := iter.Next(ctx, map[string]interface{}{})
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				docs++
+			}
+			if docs != tt.rows {
+				t.Fatalf("%d documents stored, want %d", docs, tt.rows)
+			}
+			// Every write is reported, counting the writes so far. Events
+			// are not received in order.
+			var batches []int
+			for _, msg := range receiveAll(t, w.progress) {
+				e, err := job.DecodeEvent(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e.Type == job.EventInserted {
+					batches = append(batches, int(e.BatchesInserted))
+				}
+			}
+			sort.Ints(batches)
+			if int64(len(batches)) != tt.batches {
+				t.Fatalf("%d writes, want %d", len(batches), tt.batches)
+			}
+			for i, n := range batches {
+				if n != i+1 {
+					t.Fatalf("writes reported as %v", batches)
+				}
+			}
+		})
+	}
+}
@@ -2,17 +2,28 @@ package worker
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/purge"
+	"github.com/alvarowolfx/cloud-native-go/schema"
 	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"github.com/alvarowolfx/cloud-native-go/validate"
 	"github.com/apex/log"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/trace"
 	"gocloud.dev/blob"
 	"gocloud.dev/docstore"
 	"gocloud.dev/pubsub"
@@ -23,22 +34,106 @@ import (
 type worker struct {
 	port string
 	errs chan error
+	srv  *server.Server
+
+	// receiveCtx is cancelled to stop receiving messages and processCtx to
+	// interrupt the ones being processed, see Shutdown.
+	receiveCtx     context.Context
+	stopReceiving  context.CancelFunc
+	processCtx     context.Context
+	stopProcessing context.CancelFunc
+	stopping       chan struct{}
+	inflight       sync.WaitGroup
+	shutdownOnce   sync.Once
+	shutdownErr    error
+	// pendingRetries holds a token for every failed message waiting for its
+	// retry outside of a slot.
+	pendingRetries chan struct{}
 
 	logger *log.Entry
 	coll   *docstore.Collection
+	jobs   *job.Store
 	bucket *blob.Bucket
 	sub    *pubsub.Subscription
 
+	// quarantine keeps the rows rejected by a schema definition asking so.
+	quarantine *docstore.Collection
+
+	// exports tracks the export files written to the bucket.
+	exports *export.Store
+	purger  *purge.Purger
+
+	// uploads tracks the files being uploaded in chunks or through signed
+	// URLs, swept once abandoned.
+	uploads *upload.Store
+
+	topic      *pubsub.Topic
+	deadLetter *pubsub.Topic
+	progress   *pubsub.Topic
+	opts       Options
+
 	totalFilesProcessed metric.Int64Counter
 	totalLinesProcessed metric.Int64Counter
 	totalLinesWithError metric.Int64Counter
+	totalExportsWritten metric.Int64Counter
+	busySlots           metric.Int64UpDownCounter
+	messageDuration     metric.Float64Histogram
+
+	retentionJobsDeleted      metric.Int64Counter
+	retentionDocumentsDeleted metric.Int64Counter
+	retentionBytesDeleted     metric.Int64Counter
+	retentionUploadsDeleted   metric.Int64Counter
+}
+
+type Options struct {
+	// BatchSize is the number of rows written to the collection at once.
+	BatchSize int
+	// MaxAttempts is how many times a message is processed before it is sent
+	// to the dead-letter topic.
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry, doubled on every
+	// following attempt up to MaxRetryBackoff.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Concurrency is the number of messages processed in parallel.
+	Concurrency int
+	// MaxPendingRetries is how many failed messages can wait for their retry
+	// at once. Past it, a failed message waits in its slot, so no more
+	// messages are received until it is retried.
+	MaxPendingRetries int
+	// RetentionMaxAge is how long jobs are kept and RetentionKeepLast how
+	// many of the newest jobs of every uploader of an API key are kept,
+	// unlimited when zero. Jobs are also deleted once past their own
+	// ExpiresAt.
+	RetentionMaxAge   time.Duration
+	RetentionKeepLast int
+	// RetentionInterval is how often expired jobs are looked for.
+	RetentionInterval time.Duration
+	// UploadMaxAge is how long an upload that is not finalized is kept after
+	// it was last written to, along with its chunks and file. Kept forever
+	// when zero.
+	UploadMaxAge time.Duration
+	// Limits are checked on the files of archives as they are extracted,
+	// like the API checks uploads.
+	Limits validate.Limits
+	// ArchiveMaxEntries is how many files an archive can hold,
+	// ArchiveMaxEntrySize how large each can be once extracted and
+	// ArchiveMaxDepth how many archives it can be nested in. Archives past
+	// them fail, they are not enforced when zero.
+	ArchiveMaxEntries   int
+	ArchiveMaxEntrySize int64
+	ArchiveMaxDepth     int
 }
 
+// progressInterval is how many parsed lines go by between progress events.
+const progressInterval = 1000
+
 type Worker interface {
 	Start()
+	Shutdown(ctx context.Context) error
 }
 
-func New(port string, errs chan error, coll *docstore.Collection, bucket *blob.Bucket, sub *pubsub.Subscription) Worker {
+func New(port string, errs chan error, coll, quarantine *docstore.Collection, jobs *job.Store, exports *export.Store, uploads *upload.Store, bucket *blob.Bucket, sub *pubsub.Subscription, topic, deadLetter, progress *pubsub.Topic, opts Options) Worker {
 	logger := log.WithField("module", "worker")
 	meter := global.GetMeterProvider().Meter("github.com/alvarowolfx/cloud-native-go")
 	totalFilesProcessed, err := meter.NewInt64Counter("worker.files_processed.total", metric.WithDescription("total files processed"))
@@ -47,17 +142,62 @@ func New(port string, errs chan error, coll *docstore.Collection, bucket *blob.B
 	handleOtelErr(err)
 	totalLinesWithError, err := meter.NewInt64Counter("worker.parse_errors.total", metric.WithDescription("total lines with error found"))
 	handleOtelErr(err)
-	return &worker{
+	totalExportsWritten, err := meter.NewInt64Counter("worker.exports_written.total", metric.WithDescription("total exports written"))
+	handleOtelErr(err)
+	busySlots, err := meter.NewInt64UpDownCounter("worker.slots.busy", metric.WithDescription("messages being processed per pool slot"))
+	handleOtelErr(err)
+	messageDuration, err := meter.NewFloat64Histogram("worker.message.duration", metric.WithDescription("seconds spent processing a message"))
+	handleOtelErr(err)
+	retentionJobsDeleted, err := meter.NewInt64Counter("worker.retention.jobs_deleted", metric.WithDescription("total expired jobs deleted"))
+	handleOtelErr(err)
+	retentionDocumentsDeleted, err := meter.NewInt64Counter("worker.retention.documents_deleted", metric.WithDescription("total documents of expired jobs deleted"))
+	handleOtelErr(err)
+	retentionBytesDeleted, err := meter.NewInt64Counter("worker.retention.bytes_deleted", metric.WithDescription("total size of the files of expired jobs deleted"))
+	handleOtelErr(err)
+	retentionUploadsDeleted, err := meter.NewInt64Counter("worker.retention.uploads_deleted", metric.WithDescription("total expired uploads deleted"))
+	handleOtelErr(err)
+	receiveCtx, stopReceiving := context.WithCancel(context.Background())
+	processCtx, stopProcessing := context.WithCancel(context.Background())
+	w := &worker{
 		port:                port,
 		errs:                errs,
 		logger:              logger,
 		coll:                coll,
+		jobs:                jobs,
+		exports:             exports,
+		uploads:             uploads,
+		purger:              purge.New(jobs, exports, bucket, coll, quarantine, opts.BatchSize),
+		quarantine:          quarantine,
 		bucket:              bucket,
 		sub:                 sub,
+		topic:               topic,
+		deadLetter:          deadLetter,
+		progress:            progress,
+		opts:                opts,
 		totalFilesProcessed: totalFilesProcessed,
 		totalLinesProcessed: totalLinesProcessed,
 		totalLinesWithError: totalLinesWithError,
+		totalExportsWritten: totalExportsWritten,
+		busySlots:           busySlots,
+		messageDuration:     messageDuration,
+
+		retentionJobsDeleted:      retentionJobsDeleted,
+		retentionDocumentsDeleted: retentionDocumentsDeleted,
+		retentionBytesDeleted:     retentionBytesDeleted,
+		retentionUploadsDeleted:   retentionUploadsDeleted,
+
+		receiveCtx:     receiveCtx,
+		stopReceiving:  stopReceiving,
+		processCtx:     processCtx,
+		stopProcessing: stopProcessing,
+		stopping:       make(chan struct{}),
+		pendingRetries: make(chan struct{}, opts.MaxPendingRetries),
+	}
+	srvOptions := &server.Options{
+		HealthChecks: []health.Checker{w},
 	}
+	w.srv = server.New(http.DefaultServeMux, srvOptions)
+	return w
 }
 
 func handleOtelErr(err error) {
@@ -77,99 +217,359 @@ func (w *worker) CheckHealth() error {
 }
 
 func (w *worker) Start() {
+	w.inflight.Add(1)
 	go w.listenMessages()
-	srvOptions := &server.Options{
-		HealthChecks: []health.Checker{w},
-	}
-	srv := server.New(http.DefaultServeMux, srvOptions)
+	w.inflight.Add(1)
+	go w.sweepRetention()
 
 	w.logger.Infof("listening on port %s", w.port)
-	err := srv.ListenAndServe(":" + w.port)
-	if err != nil {
+	err := w.srv.ListenAndServe(":" + w.port)
+	if err != nil && err != http.ErrServerClosed {
 		w.errs <- err
 	}
 }
 
-func (w *worker) downloadAndParse(ctx context.Context, jobId string) ([]map[string]interface{}, error) {
-	r, err := w.bucket.NewReader(ctx, jobId, nil)
+// inferSchema reads the whole job file once to find the type of every
+// column before any row is stored.
+func (w *worker) inferSchema(ctx context.Context, j *job.Job) ([]schema.Column, error) {
+	rows, err := w.openRows(ctx, j)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
+		return nil, err
 	}
-	csvReader := csv.NewReader(r)
-	csvReader.LazyQuotes = true
+	defer rows.Close()
+	inferrer := schema.NewInferrer(rows.fields)
+	for {
+		values, err := rows.next()
+		if err == io.EOF {
+			return inferrer.Columns(), nil
+		}
+		var le *lineError
+		if errors.As(err, &le) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		inferrer.Observe(values)
+	}
+}
+
+// ingest streams the job file from the bucket and stores its rows, typed
+// after the job schema, in batches of BatchSize so memory usage does not
+// depend on the file size.
+func (w *worker) ingest(ctx context.Context, j *job.Job) error {
+	tracer := otel.Tracer("worker")
+
+	def := j.SchemaDefinition
+	var inferred []schema.Column
+	if def == nil || def.KeepUndeclared {
+		ctx, spanInfer := tracer.Start(ctx, "schema.infer")
+		columns, err := w.inferSchema(ctx, j)
+		spanInfer.End()
+		if err != nil {
+			return err
+		}
+		inferred = columns
+	}
+
+	rows, err := w.openRows(ctx, j)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	header, err := csvReader.Read()
+	mappings, err := schema.Plan(rows.fields, inferred, def)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read header: %v", err)
+		return permanent(err)
 	}
-	records := make([]map[string]interface{}, 0)
+	for i := range mappings {
+		mappings[i].Source = rows.header[i]
+	}
+	j.Schema = schema.Columns(mappings)
+	_, err = w.jobs.Update(ctx, j.ID, func(current *job.Job) error {
+		current.Schema = j.Schema
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	quarantine := def != nil && def.OnError == schema.OnErrorQuarantine
+
+	var batches int64
+	batch := make([]map[string]interface{}, 0, w.opts.BatchSize)
+	quarantined := make([]map[string]interface{}, 0)
+	flush := func() error {
+		if len(batch) == 0 && len(quarantined) == 0 {
+			return nil
+		}
+		ctx, spanInsert := tracer.Start(ctx, "db.insert")
+		defer spanInsert.End()
+		actionList := w.coll.Actions()
+		for _, record := range batch {
+			actionList.Put(record)
+		}
+		if err := actionList.Do(ctx); err != nil {
+			return fmt.Errorf("failed to save records: %v", summarizeActionListError(err, len(batch)))
+		}
+		if len(quarantined) > 0 {
+			actionList := w.quarantine.Actions()
+			for _, record := range quarantined {
+				actionList.Put(record)
+			}
+			if err := actionList.Do(ctx); err != nil {
+				return fmt.Errorf("failed to quarantine records: %v", summarizeActionListError(err, len(quarantined)))
+			}
+		}
+		batches++
+		w.totalLinesProcessed.Add(ctx, int64(len(batch)), slotAttr(ctx))
+		batch = batch[:0]
+		quarantined = quarantined[:0]
+		w.publishProgress(ctx, job.Event{
+			JobID:           j.ID,
+			Type:            job.EventInserted,
+			LinesParsed:     j.LinesProcessed + j.LinesRejected,
+			LinesRejected:   j.LinesRejected,
+			BatchesInserted: batches,
+		})
+		return nil
+	}
+
 	for {
-		line, err := csvReader.Read()
+		values, err := rows.next()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			w.totalLinesWithError.Add(ctx, 1)
-			w.logger.Errorf("failed to read csv: %v", err)
+		if err == nil {
+			var record map[string]interface{}
+			record, err = buildRecord(j.ID, j.Owner, mappings, rows.line, values)
+			if err == nil {
+				batch = append(batch, record)
+				j.LinesProcessed++
+			}
+		}
+		var le *lineError
+		if errors.As(err, &le) {
+			w.totalLinesWithError.Add(ctx, 1, slotAttr(ctx))
+			w.logger.Errorf("failed to read line: %v", err)
+			j.RejectLine(le.line, le.err.Error())
+			if quarantine {
+				quarantined = append(quarantined, quarantineRecord(j.ID, rows.fields, le, values))
+			}
+		} else if err != nil {
+			return err
+		}
+		if (j.LinesProcessed+j.LinesRejected)%progressInterval == 0 {
+			w.publishProgress(ctx, job.Event{
+				JobID:         j.ID,
+				Type:          job.EventParsed,
+				LinesParsed:   j.LinesProcessed + j.LinesRejected,
+				LinesRejected: j.LinesRejected,
+			})
+		}
+		if len(batch)+len(quarantined) >= w.opts.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// buildRecord converts the values of a line to the document stored for it,
+// validating them against the column mappings. The document is tagged with
+// the owner of its job, which is what queries are restricted by.
+func buildRecord(jobId, owner string, mappings []schema.Mapping, line int, values []string) (map[string]interface{}, error) {
+	record := map[string]interface{}{}
+	if len(values) > len(mappings) {
+		return nil, &lineError{line: line, err: fmt.Errorf("row has %d values, the header has %d columns", len(values), len(mappings))}
+	}
+	for i, v := range values {
+		m := mappings[i]
+		if m.Skip {
 			continue
 		}
-		record := map[string]interface{}{
-			"jobId": jobId,
+		value, err := schema.Convert(m.Type, v)
+		if err != nil {
+			return nil, &lineError{line: line, err: fmt.Errorf("column %s: %v", m.Name, err)}
+		}
+		if value == nil && m.Required {
+			return nil, &lineError{line: line, err: fmt.Errorf("column %s: value is required", m.Name)}
 		}
-		for i, v := range line {
-			h := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(header[i], "\"", "")))
-			cv := strings.TrimSpace(strings.ReplaceAll(v, "\"", ""))
-			record[h] = cv
+		record[m.Name] = value
+	}
+	// Set last so that columns of the file can not take their place.
+	record["id"] = job.RecordID(jobId, line)
+	record["jobId"] = jobId
+	record["ownerId"] = owner
+	return record, nil
+}
+
+// quarantineRecord keeps the raw values of a rejected line along with the
+// reason it was rejected.
+func quarantineRecord(jobId string, fields []string, le *lineError, values []string) map[string]interface{} {
+	raw := map[string]interface{}{}
+	for i, v := range values {
+		if i < len(fields) {
+			raw[fields[i]] = v
 		}
-		records = append(records, record)
-		w.totalLinesProcessed.Add(ctx, 1)
 	}
-	err = r.Close()
+	return map[string]interface{}{
+		"id":     job.RecordID(jobId, le.line),
+		"jobId":  jobId,
+		"line":   le.line,
+		"error":  le.err.Error(),
+		"values": raw,
+	}
+}
+
+// summarizeActionListError keeps only the first failure of a batch write, the
+// full error repeats it for every action.
+func summarizeActionListError(err error, total int) error {
+	var alErr docstore.ActionListError
+	if !errors.As(err, &alErr) || len(alErr) == 0 {
+		return err
+	}
+	return fmt.Errorf("%d of %d writes failed, first at %d: %w", len(alErr), total, alErr[0].Index, alErr[0].Err)
+}
+
+func (w *worker) publishProgress(ctx context.Context, e job.Event) {
+	if err := job.PublishEvent(ctx, w.progress, e); err != nil {
+		w.logger.Errorf("failed to publish progress of job %s: %v", e.JobID, err)
+	}
+}
+
+func (w *worker) failJob(ctx context.Context, jobId string, reason error) {
+	j, err := w.jobs.Transition(ctx, jobId, job.StateFailed, reason.Error())
 	if err != nil {
-		return nil, fmt.Errorf("failed to close file: %v", err)
+		w.logger.Errorf("failed to mark job %s as failed: %v", jobId, err)
+		return
 	}
-	return records, nil
+	w.publishProgress(ctx, job.Event{
+		JobID: jobId,
+		Type:  job.EventFailed,
+		State: j.State,
+		Error: j.Error,
+	})
 }
 
-func (w *worker) listenMessages() {
-	for {
-		ctx := context.Background()
-		msg, err := w.sub.Receive(ctx)
-		if err != nil {
-			log.Errorf("failed to receive message: %v", err)
-			continue
+func (w *worker) handleMessage(ctx context.Context, msg *pubsub.Message) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
+
+	tracer := otel.Tracer("worker")
+	ctx, span := tracer.Start(ctx, "processing", trace.WithAttributes(slotAttr(ctx)))
+	defer span.End()
+
+	t := w.messageTask(msg)
+	w.logger.Infof("received message: %s - %v - %s", t, msg.Metadata, span.SpanContext().TraceID().String())
+
+	start := time.Now()
+	processCtx, cancel := w.processingContext(ctx)
+	err := t.process(processCtx)
+	cancel()
+	outcome := "acked"
+	if err != nil {
+		outcome = "failed"
+	}
+	w.messageDuration.Record(ctx, time.Since(start).Seconds(), slotAttr(ctx), attribute.String("outcome", outcome))
+	if err != nil && w.processCtx.Err() != nil {
+		w.logger.Warnf("%s interrupted by shutdown: %v", t, err)
+		w.requeue(ctx, msg, t)
+		return
+	}
+	if err != nil {
+		w.logger.Errorf("failed to process %s: %v", t, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		select {
+		case w.pendingRetries <- struct{}{}:
+			w.inflight.Add(1)
+			go func() {
+				defer w.inflight.Done()
+				defer func() { <-w.pendingRetries }()
+				w.retry(ctx, msg, t, err)
+			}()
+		default:
+			w.retry(ctx, msg, t, err)
 		}
-		ctx = otel.GetTextMapPropagator().Extract(ctx, telemetry.PubsubMetadataCarrier(msg.Metadata))
+		return
+	}
 
-		tracer := otel.Tracer("worker")
-		ctx, span := tracer.Start(ctx, "processing")
+	msg.Ack()
+	span.AddEvent("acked")
+}
 
-		jobId := string(msg.Body)
-		w.logger.Infof("received message: %s - %v - %s", jobId, msg.Metadata, span.SpanContext().TraceID().String())
+func (w *worker) processJob(ctx context.Context, jobId string) error {
+	tracer := otel.Tracer("worker")
 
-		ctx, spanDownloadFile := tracer.Start(ctx, "file.download")
-		records, err := w.downloadAndParse(ctx, jobId)
+	j, err := w.jobs.Transition(ctx, jobId, job.StateProcessing, "")
+	if errors.Is(err, job.ErrInvalidTransition) || errors.Is(err, job.ErrNotFound) {
+		w.logger.Warnf("skipping job %s: %v", jobId, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// A reprocessed job may leave documents of the previous run behind, as
+	// when it is read with other options.
+	if len(j.Runs) > 0 {
+		ctx, spanClear := tracer.Start(ctx, "db.clear")
+		_, err = w.purger.DeleteDocuments(ctx, jobId)
+		spanClear.End()
 		if err != nil {
-			w.logger.Errorf("failed to download and parse file: %v", err)
-			continue
+			return fmt.Errorf("failed to clear documents: %w", err)
 		}
-		spanDownloadFile.End()
-		w.totalFilesProcessed.Add(ctx, 1)
-		span.AddEvent("file.read")
+	}
 
-		ctx, spanInsert := tracer.Start(ctx, "db.insert")
-		actionList := w.coll.Actions()
-		for _, record := range records {
-			actionList.Create(record)
+	var expand func(context.Context, *job.Job) error
+	switch {
+	case j.Parse.Compression == parser.CompressionZip:
+		expand = w.expandArchive
+	case j.Parse.Format == parser.FormatXLSX && j.Parse.Sheet == parser.AllSheets:
+		expand = w.expandWorkbook
+	}
+	if expand != nil {
+		ctx, spanExpand := tracer.Start(ctx, "file.expand")
+		err = expand(ctx, j)
+		spanExpand.End()
+		if err != nil {
+			return fmt.Errorf("failed to expand file: %w", err)
 		}
-		if err := actionList.Do(ctx); err != nil {
-			w.logger.Errorf("failed to save records: %v", err)
-			continue
+		j, err = w.jobs.Transition(ctx, jobId, job.StateCompleted, "")
+		if err != nil {
+			return fmt.Errorf("failed to complete job: %w", err)
 		}
-		spanInsert.End()
+		w.publishProgress(ctx, job.Event{
+			JobID: jobId,
+			Type:  job.EventCompleted,
+			State: j.State,
+		})
+		return nil
+	}
+
+	ctx, spanIngest := tracer.Start(ctx, "file.ingest")
+	err = w.ingest(ctx, j)
+	spanIngest.End()
+	if err != nil {
+		return fmt.Errorf("failed to ingest file: %w", err)
+	}
+	w.totalFilesProcessed.Add(ctx, 1, slotAttr(ctx))
 
-		msg.Ack()
-		span.AddEvent("acked")
-		span.End()
+	j, err = w.jobs.Update(ctx, jobId, func(current *job.Job) error {
+		current.LinesProcessed = j.LinesProcessed
+		current.LinesRejected = j.LinesRejected
+		current.LineErrors = j.LineErrors
+		return current.Transition(job.StateCompleted, "")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
 	}
+	w.publishProgress(ctx, job.Event{
+		JobID:         jobId,
+		Type:          job.EventCompleted,
+		State:         j.State,
+		LinesParsed:   j.LinesProcessed + j.LinesRejected,
+		LinesRejected: j.LinesRejected,
+	})
+	return nil
 }
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type slotKey struct{}
+
+func withSlot(ctx context.Context, slot int) context.Context {
+	return context.WithValue(ctx, slotKey{}, slot)
+}
+
+// slotAttr labels metrics with the pool slot processing the current message.
+func slotAttr(ctx context.Context) attribute.KeyValue {
+	slot, _ := ctx.Value(slotKey{}).(int)
+	return attribute.Int("worker.slot", slot)
+}
+
+// listenMessages receives messages while one of the Concurrency slots is
+// free and processes each of them in its own goroutine. Every message is
+// acked or nacked by the goroutine holding it.
+func (w *worker) listenMessages() {
+	defer w.inflight.Done()
+	slots := make(chan int, w.opts.Concurrency)
+	for i := 0; i < w.opts.Concurrency; i++ {
+		slots <- i
+	}
+	for {
+		slot := <-slots
+		msg, err := w.sub.Receive(w.receiveCtx)
+		if err != nil {
+			slots <- slot
+			if w.receiveCtx.Err() != nil {
+				return
+			}
+			w.logger.Errorf("failed to receive message: %v", err)
+			continue
+		}
+		w.inflight.Add(1)
+		go func() {
+			defer w.inflight.Done()
+			defer func() { slots <- slot }()
+			ctx := withSlot(context.Background(), slot)
+			w.busySlots.Add(ctx, 1, slotAttr(ctx))
+			defer w.busySlots.Add(ctx, -1, slotAttr(ctx))
+			w.handleMessage(ctx, msg)
+		}()
+	}
+}
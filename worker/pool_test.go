@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"gocloud.dev/pubsub"
+)
+
+func TestHandleMessageRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		// pending is how many retries already wait.
+		pending int
+		// background retries free the slot of the message while they wait.
+		background bool
+	}{
+		{name: "room for the retry", max: 2, background: true},
+		{name: "room for the last retry", max: 2, pending: 1, background: true},
+		{name: "no room for the retry", max: 1, pending: 1},
+		{name: "no retries waiting", max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Retries wait until the shutdown.
+			w := newTestWorker(t, Options{MaxPendingRetries: tt.max, RetryBackoff: time.Hour, MaxRetryBackoff: time.Hour})
+			for i := 0; i < tt.pending; i++ {
+				w.pendingRetries <- struct{}{}
+			}
+			w.storeJob(t, &job.Job{ID: "j", State: job.StateQueued, Parse: parser.Options{Format: parser.FormatCSV}}, "a\n1\n")
+			// Storing the rows fails, which is worth retrying.
+			w.coll.Close()
+			msg := w.receiveSent(t, &pubsub.Message{Body: []byte("j"), Metadata: map[string]string{"eventType": "file.upload"}})
+
+			// As the pool runs it.
+			handled := make(chan struct{})
+			w.inflight.Add(1)
+			go func() {
+				defer w.inflight.Done()
+				w.handleMessage(context.Background(), msg)
+				close(handled)
+			}()
+			select {
+			case <-handled:
+				if !tt.background {
+					t.Fatal("slot freed while the retry waits")
+				}
+				if len(w.pendingRetries) != tt.pending+1 {
+					t.Fatalf("%d retries waiting, want %d", len(w.pendingRetries), tt.pending+1)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.background {
+					t.Fatal("slot held while the retry waits")
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// It fails to close the collection closed above.
+			w.Shutdown(ctx)
+			<-handled
+			if len(w.pendingRetries) != tt.pending {
+				t.Fatalf("%d retries waiting after the retry, want %d", len(w.pendingRetries), tt.pending)
+			}
+			if queued := receiveAll(t, w.queued); len(queued) != 1 || queued[0].Metadata[attemptKey] != "2" {
+				t.Fatalf("republished %d messages, want one for attempt 2", len(queued))
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"go.opentelemetry.io/otel"
+)
+
+// processPurge deletes the data of a job the API marked as deleting. A
+// redelivered message resumes with what is left.
+func (w *worker) processPurge(ctx context.Context, jobId string) error {
+	tracer := otel.Tracer("worker")
+
+	ctx, span := tracer.Start(ctx, "job.delete")
+	j, err := w.purger.Purge(ctx, jobId)
+	span.End()
+	if errors.Is(err, job.ErrInvalidTransition) || errors.Is(err, job.ErrNotFound) {
+		w.logger.Warnf("skipping deletion of job %s: %v", jobId, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete job: %w", err)
+	}
+	w.publishProgress(ctx, job.Event{
+		JobID: jobId,
+		Type:  job.EventDeleted,
+		State: j.State,
+	})
+	return nil
+}
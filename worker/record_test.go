@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alvarowolfx/cloud-native-go/schema"
+)
+
+func TestBuildRecord(t *testing.T) {
+	mappings := []schema.Mapping{
+		{Name: "price", Type: schema.TypeFloat, Required: true},
+		{Name: "city", Type: schema.TypeString},
+		{Name: "notes", Type: schema.TypeString, Skip: true},
+	}
+	tests := []struct {
+		name     string
+		mappings []schema.Mapping
+		values   []string
+		want     map[string]interface{}
+		// invalid rows are rejected with a *lineError.
+		invalid bool
+	}{
+		{
+			name:   "all values",
+			values: []string{"10.5", "Boston", "skipped"},
+			want:   map[string]interface{}{"price": 10.5, "city": "Boston"},
+		},
+		{
+			name:   "short row",
+			values: []string{"10.5"},
+			want:   map[string]interface{}{"price": 10.5},
+		},
+		{name: "too long row", values: []string{"10.5", "Boston", "", "extra"}, invalid: true},
+		{name: "invalid value", values: []string{"cheap", "Boston"}, invalid: true},
+		{name: "missing required value", values: []string{"", "Boston"}, invalid: true},
+		{
+			// Plan rejects these names, the record is safe either way.
+			name:     "reserved names",
+			mappings: []schema.Mapping{{Name: "id", Type: schema.TypeString}, {Name: "jobId", Type: schema.TypeString}, {Name: "ownerId", Type: schema.TypeString}},
+			values:   []string{"x", "other", "mallory"},
+			want:     map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.mappings
+			if m == nil {
+				m = mappings
+			}
+			record, err := buildRecord("j", "k", m, 7, tt.values)
+			if tt.invalid {
+				var le *lineError
+				if !errors.As(err, &le) || le.line != 7 {
+					t.Fatalf("error %v, want a *lineError on line 7", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]interface{}{"id": "j-0000000007", "jobId": "j", "ownerId": "k"}
+			for k, v := range tt.want {
+				want[k] = v
+			}
+			if !reflect.DeepEqual(record, want) {
+				t.Fatalf("record %v, want %v", record, want)
+			}
+		})
+	}
+}
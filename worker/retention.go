@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"gocloud.dev/gcerrors"
+)
+
+// Reasons a job is deleted by the retention sweeper, recorded on its
+// metrics.
+const (
+	retentionTTL      = "ttl"
+	retentionMaxAge   = "max_age"
+	retentionKeepLast = "keep_last"
+	// retentionResume is a deletion left unfinished, as by a shutdown.
+	retentionResume = "resume"
+)
+
+// sweepRetention deletes expired jobs every RetentionInterval until the
+// worker stops receiving messages. Replicas sweep concurrently, which is
+// safe as deleting a job twice does nothing more.
+func (w *worker) sweepRetention() {
+	defer w.inflight.Done()
+	ticker := time.NewTicker(w.opts.RetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.receiveCtx.Done():
+			return
+		case <-ticker.C:
+			// A sweep under way is interrupted like in-flight messages.
+			w.sweep(w.processCtx)
+		}
+	}
+}
+
+// sweep deletes the jobs past their ExpiresAt or the retention limits. Child
+// jobs are deleted along with their parent, so only top level jobs are
+// looked at.
+func (w *worker) sweep(ctx context.Context) {
+	tracer := otel.Tracer("worker")
+	ctx, span := tracer.Start(ctx, "retention.sweep")
+	defer span.End()
+
+	now := time.Now().UTC()
+	byUploader := map[string][]*job.Job{}
+	expired := map[string]string{}
+	err := w.jobs.Each(ctx, func(j *job.Job) error {
+		if j.ParentID != "" || j.State == job.StateDeleted {
+			return nil
+		}
+		if j.State == job.StateDeleting {
+			// Deletions requested through the API are left to their
+			// message for a while.
+			if now.Sub(j.UpdatedAt) > w.opts.RetentionInterval {
+				expired[j.ID] = retentionResume
+			}
+			return nil
+		}
+		switch {
+		case !j.ExpiresAt.IsZero() && now.After(j.ExpiresAt):
+			expired[j.ID] = retentionTTL
+		case w.opts.RetentionMaxAge > 0 && now.Sub(j.CreatedAt) > w.opts.RetentionMaxAge:
+			expired[j.ID] = retentionMaxAge
+		}
+		// Uploaders are named by the client, so they are counted apart for
+		// every key: one key can not push the jobs of another out.
+		uploader := j.Owner + "/" + j.Uploader
+		// Trimmed to the fields needed, as every job is kept until the
+		// end of the sweep.
+		byUploader[uploader] = append(byUploader[uploader], &job.Job{ID: j.ID, CreatedAt: j.CreatedAt})
+		return nil
+	})
+	if err != nil {
+		w.logger.Errorf("failed to look for expired jobs: %v", err)
+		return
+	}
+	if keep := w.opts.RetentionKeepLast; keep > 0 {
+		for _, jobs := range byUploader {
+			if len(jobs) <= keep {
+				continue
+			}
+			sort.Slice(jobs, func(a, b int) bool {
+				return jobs[a].CreatedAt.After(jobs[b].CreatedAt)
+			})
+			for _, j := range jobs[keep:] {
+				if _, ok := expired[j.ID]; !ok {
+					expired[j.ID] = retentionKeepLast
+				}
+			}
+		}
+	}
+
+	var deleted int
+	for jobId, reason := range expired {
+		if ctx.Err() != nil {
+			return
+		}
+		if w.deleteExpired(ctx, jobId, reason) {
+			deleted++
+		}
+	}
+	w.logger.Infof("retention sweep deleted %d of %d expired jobs", deleted, len(expired))
+
+	if w.opts.UploadMaxAge > 0 {
+		w.sweepUploads(ctx, now)
+	}
+}
+
+// sweepUploads deletes the uploads left unfinished for longer than
+// UploadMaxAge, with their chunks and the file written through their signed
+// URL, and the records of finalized uploads whose job was deleted.
+func (w *worker) sweepUploads(ctx context.Context, now time.Time) {
+	var expired []*upload.Upload
+	err := w.uploads.Each(ctx, func(u *upload.Upload) error {
+		if now.Sub(u.UpdatedAt) > w.opts.UploadMaxAge {
+			expired = append(expired, u)
+		}
+		return nil
+	})
+	if err != nil {
+		w.logger.Errorf("failed to look for expired uploads: %v", err)
+		return
+	}
+
+	var deleted int
+	for _, u := range expired {
+		if ctx.Err() != nil {
+			return
+		}
+		if w.deleteUpload(ctx, u) {
+			deleted++
+		}
+	}
+	w.logger.Infof("retention sweep deleted %d of %d expired uploads", deleted, len(expired))
+}
+
+// deleteUpload deletes an expired upload and tells whether it did. Uploads
+// that already have a job are left to it, the file being the job's.
+func (w *worker) deleteUpload(ctx context.Context, u *upload.Upload) bool {
+	jobId := u.ID
+	if u.State == upload.StateCompleted {
+		jobId = u.JobID
+	}
+	_, err := w.jobs.Get(ctx, jobId)
+	if err != nil && !errors.Is(err, job.ErrNotFound) {
+		w.logger.Errorf("failed to delete expired upload %s: %v", u.ID, err)
+		return false
+	}
+	hasJob := err == nil
+	if hasJob && u.State == upload.StateCompleted {
+		return false
+	}
+
+	// The record goes first: a chunk or finalize racing with the sweep then
+	// fails rather than using files being deleted.
+	if err := w.uploads.Delete(ctx, u.ID); err != nil {
+		if !errors.Is(err, upload.ErrNotFound) {
+			w.logger.Errorf("failed to delete expired upload %s: %v", u.ID, err)
+		}
+		return false
+	}
+	keys := make([]string, 0, len(u.Parts)+1)
+	for _, p := range u.Parts {
+		keys = append(keys, p.Key)
+	}
+	if !hasJob {
+		// Written through a signed URL, or by a finalize that stopped
+		// before creating the job.
+		keys = append(keys, u.ID)
+	}
+	for _, key := range keys {
+		if err := w.bucket.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			w.logger.Errorf("failed to delete file %s of expired upload %s: %v", key, u.ID, err)
+		}
+	}
+	w.retentionUploadsDeleted.Add(ctx, 1)
+	return true
+}
+
+// deleteExpired deletes the data of a job, skipping it while it is being
+// processed, and tells whether it did.
+func (w *worker) deleteExpired(ctx context.Context, jobId, reason string) bool {
+	if reason != retentionResume {
+		_, err := w.purger.Start(ctx, jobId)
+		if errors.Is(err, job.ErrInvalidTransition) || errors.Is(err, job.ErrNotFound) {
+			return false
+		}
+		if err != nil {
+			w.logger.Errorf("failed to delete expired job %s: %v", jobId, err)
+			return false
+		}
+	}
+	j, err := w.purger.Purge(ctx, jobId)
+	if err != nil {
+		w.logger.Errorf("failed to delete expired job %s: %v", jobId, err)
+		return false
+	}
+	attrs := attribute.String("reason", reason)
+	w.retentionJobsDeleted.Add(ctx, 1, attrs)
+	w.retentionDocumentsDeleted.Add(ctx, j.DocumentsDeleted, attrs)
+	w.retentionBytesDeleted.Add(ctx, j.Size, attrs)
+	w.publishProgress(ctx, job.Event{
+		JobID: jobId,
+		Type:  job.EventDeleted,
+		State: j.State,
+	})
+	return true
+}
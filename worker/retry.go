@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/telemetry"
+	"go.opentelemetry.io/otel"
+	"gocloud.dev/pubsub"
+)
+
+const attemptKey = "attempt"
+
+// permanentError marks failures that will not succeed on a retry, like a
+// missing or unreadable file.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+func messageAttempt(msg *pubsub.Message) int {
+	n, err := strconv.Atoi(msg.Metadata[attemptKey])
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (w *worker) backoff(attempt int) time.Duration {
+	d := w.opts.RetryBackoff
+	for i := 1; i < attempt && d < w.opts.MaxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > w.opts.MaxRetryBackoff {
+		d = w.opts.MaxRetryBackoff
+	}
+	return d
+}
+
+func nack(msg *pubsub.Message) {
+	if msg.Nackable() {
+		msg.Nack()
+	}
+}
+
+func copyMetadata(msg *pubsub.Message) map[string]string {
+	md := make(map[string]string, len(msg.Metadata)+1)
+	for k, v := range msg.Metadata {
+		md[k] = v
+	}
+	return md
+}
+
+// retry republishes a failed message with its attempt count increased after
+// an exponential backoff, or moves it to the dead-letter topic and fails the
+// task once MaxAttempts is reached. The original message is only acked after
+// the copy was published, otherwise it is nacked for redelivery.
+func (w *worker) retry(ctx context.Context, msg *pubsub.Message, t task, cause error) {
+	attempt := messageAttempt(msg)
+	if attempt >= w.opts.MaxAttempts || isPermanent(cause) {
+		w.deadLetterMessage(ctx, msg, t, attempt, cause)
+		return
+	}
+
+	if err := t.queue(ctx, cause.Error()); err != nil {
+		w.logger.Errorf("failed to requeue %s: %v", t, err)
+	}
+
+	delay := w.backoff(attempt)
+	w.logger.Infof("retrying %s in %s (attempt %d of %d)", t, delay, attempt+1, w.opts.MaxAttempts)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-w.stopping:
+		// Republish right away so the retry is not lost with this replica.
+	}
+
+	next := &pubsub.Message{
+		Body:     msg.Body,
+		Metadata: copyMetadata(msg),
+	}
+	next.Metadata[attemptKey] = strconv.Itoa(attempt + 1)
+	otel.GetTextMapPropagator().Inject(ctx, telemetry.PubsubMetadataCarrier(next.Metadata))
+	if err := w.topic.Send(ctx, next); err != nil {
+		w.logger.Errorf("failed to republish %s: %v", t, err)
+		nack(msg)
+		return
+	}
+	msg.Ack()
+}
+
+func (w *worker) deadLetterMessage(ctx context.Context, msg *pubsub.Message, t task, attempt int, cause error) {
+	dead := &pubsub.Message{
+		Body:     msg.Body,
+		Metadata: copyMetadata(msg),
+	}
+	dead.Metadata[attemptKey] = strconv.Itoa(attempt)
+	dead.Metadata["error"] = cause.Error()
+	if err := w.deadLetter.Send(ctx, dead); err != nil {
+		w.logger.Errorf("failed to dead-letter %s: %v", t, err)
+		nack(msg)
+		return
+	}
+	reason := cause
+	if !isPermanent(cause) {
+		reason = fmt.Errorf("gave up after %d attempts: %v", attempt, cause)
+	}
+	t.fail(ctx, reason)
+	msg.Ack()
+}
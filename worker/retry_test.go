@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"gocloud.dev/pubsub"
+)
+
+func TestBackoff(t *testing.T) {
+	w := &worker{opts: Options{RetryBackoff: time.Second, MaxRetryBackoff: 5 * time.Second}}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{60, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempt), func(t *testing.T) {
+			if got := w.backoff(tt.attempt); got != tt.want {
+				t.Fatalf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageAttempt(t *testing.T) {
+	tests := []struct {
+		attempt string
+		want    int
+	}{
+		{"", 1},
+		{"3", 3},
+		{"0", 1},
+		{"-2", 1},
+		{"x", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.attempt, func(t *testing.T) {
+			msg := &pubsub.Message{Metadata: map[string]string{attemptKey: tt.attempt}}
+			if got := messageAttempt(msg); got != tt.want {
+				t.Fatalf("attempt %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// receiveSent sends msg to the topic of w and receives it back, for it to be
+// acked or nacked like a message of the subscription.
+func (w *testWorker) receiveSent(t *testing.T, msg *pubsub.Message) *pubsub.Message {
+	t.Helper()
+	ctx := context.Background()
+	if err := w.in.Send(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	received, err := w.sub.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		attempt string
+		cause   error
+		// next is the attempt of the republished message, 0 when it is
+		// dead-lettered instead.
+		next  int
+		state job.State
+		error string
+	}{
+		{name: "first failure", cause: errors.New("timeout"), next: 2, state: job.StateQueued, error: "timeout"},
+		{name: "later failure", attempt: "2", cause: errors.New("timeout"), next: 3, state: job.StateQueued, error: "timeout"},
+		{name: "last attempt", attempt: "3", cause: errors.New("timeout"), state: job.StateFailed, error: "gave up after 3 attempts: timeout"},
+		{name: "permanent", cause: permanent(errors.New("invalid file")), state: job.StateFailed, error: "invalid file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorker(t, Options{MaxAttempts: 3, RetryBackoff: time.Millisecond, MaxRetryBackoff: time.Millisecond})
+			w.storeJob(t, &job.Job{ID: "j", State: job.StateProcessing}, "a\n1\n")
+			msg := w.receiveSent(t, &pubsub.Message{
+				Body:     []byte("j"),
+				Metadata: map[string]string{"eventType": "file.upload", attemptKey: tt.attempt},
+			})
+
+			w.retry(ctx, msg, w.messageTask(msg), tt.cause)
+
+			queued := receiveAll(t, w.queued)
+			dead := receiveAll(t, w.dead)
+			if tt.next > 0 {
+				if len(queued) != 1 || len(dead) != 0 {
+					t.Fatalf("%d republished and %d dead-lettered, want 1 and 0", len(queued), len(dead))
+				}
+				if got := queued[0].Metadata[attemptKey]; got != strconv.Itoa(tt.next) {
+					t.Fatalf("republished as attempt %s, want %d", got, tt.next)
+				}
+				if string(queued[0].Body) != "j" || queued[0].Metadata["eventType"] != "file.upload" {
+					t.Fatalf("republished %q with %v", queued[0].Body, queued[0].Metadata)
+				}
+			} else {
+				if len(queued) != 0 || len(dead) != 1 {
+					t.Fatalf("%d republished and %d dead-lettered, want 0 and 1", len(queued), len(dead))
+				}
+				if !strings.Contains(dead[0].Metadata["error"], tt.cause.Error()) {
+					t.Fatalf("dead-lettered with error %q, want %q", dead[0].Metadata["error"], tt.cause)
+				}
+			}
+			j, err := w.jobs.Get(ctx, "j")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.State != tt.state || j.Error != tt.error {
+				t.Fatalf("job %s with error %q, want %s with %q", j.State, j.Error, tt.state, tt.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alvarowolfx/cloud-native-go/cloud"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/parser"
+	"github.com/alvarowolfx/cloud-native-go/schema"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// lineError is a problem with a single line of the file, which is rejected
+// while the rest of the file is still ingested.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+// rowReader reads the rows of a job file with cleaned up header names and
+// values. header keeps the names as written in the file.
+type rowReader struct {
+	// r is the file as stored, nil for workbooks, and body its content.
+	r      *blob.Reader
+	src    *source
+	body   io.Closer
+	parser parser.Reader
+	header []string
+	fields []string
+	line   int
+}
+
+// source reads the stored file, keeping its first error: other errors come
+// from the content of the file, which is read the same on a retry.
+type source struct {
+	r   io.Reader
+	ra  io.ReaderAt
+	err error
+}
+
+func (s *source) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.record(err)
+	return n, err
+}
+
+func (s *source) ReadAt(p []byte, off int64) (int, error) {
+	n, err := s.ra.ReadAt(p, off)
+	s.record(err)
+	return n, err
+}
+
+func (s *source) record(err error) {
+	if err != nil && err != io.EOF && s.err == nil {
+		s.err = err
+	}
+}
+
+// check returns err as permanent unless reading the file failed.
+func (s *source) check(err error) error {
+	if s.err != nil {
+		return fmt.Errorf("failed to read file: %v", s.err)
+	}
+	return permanent(err)
+}
+
+func (w *worker) openRows(ctx context.Context, j *job.Job) (*rowReader, error) {
+	if j.Parse.Format == parser.FormatXLSX {
+		return w.openSheetRows(ctx, j)
+	}
+	r, err := w.bucket.NewReader(ctx, j.FileKey(), nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, permanent(fmt.Errorf("file not found: %v", err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	src := &source{r: r}
+	body, _, err := parser.Decompress(src)
+	if err != nil {
+		r.Close()
+		return nil, src.check(err)
+	}
+	p, err := parser.NewReader(body, j.Parse)
+	if err != nil {
+		body.Close()
+		r.Close()
+		return nil, src.check(err)
+	}
+	return newRowReader(r, src, body, p), nil
+}
+
+// openSheetRows reads a sheet of a workbook job through range reads, since
+// workbooks need random access.
+func (w *worker) openSheetRows(ctx context.Context, j *job.Job) (*rowReader, error) {
+	ra, err := cloud.NewBlobReaderAt(ctx, w.bucket, j.FileKey())
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, permanent(fmt.Errorf("file not found: %v", err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	src := &source{ra: ra}
+	wb, err := parser.OpenWorkbook(src, ra.Size())
+	if err != nil {
+		return nil, src.check(err)
+	}
+	sr, err := wb.NewReader(j.Parse.Sheet)
+	if err != nil {
+		return nil, src.check(err)
+	}
+	return newRowReader(nil, src, sr, sr), nil
+}
+
+func newRowReader(r *blob.Reader, src *source, body io.Closer, p parser.Reader) *rowReader {
+	header := p.Header()
+	fields := make([]string, len(header))
+	for i, h := range header {
+		fields[i] = schema.NormalizeName(h)
+	}
+	return &rowReader{r: r, src: src, body: body, parser: p, header: header, fields: fields, line: p.Line()}
+}
+
+// next returns the values of the next row, io.EOF at the end of the file or
+// a *lineError when only the current line is invalid. Other errors are
+// permanent when the file is malformed. The returned slice is reused by the
+// following call.
+func (rr *rowReader) next() ([]string, error) {
+	values, err := rr.parser.Next()
+	rr.line = rr.parser.Line()
+	var le *parser.LineError
+	if errors.As(err, &le) {
+		return nil, &lineError{line: le.Line, err: le.Err}
+	}
+	if err != nil && err != io.EOF {
+		return nil, rr.src.check(fmt.Errorf("failed to read file: %v", err))
+	}
+	return values, err
+}
+
+func (rr *rowReader) Close() error {
+	err := rr.body.Close()
+	if rr.r != nil {
+		err = rr.r.Close()
+	}
+	return err
+}
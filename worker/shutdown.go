@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// requeueTimeout bounds the cleanup of a task interrupted by shutdown.
+const requeueTimeout = 5 * time.Second
+
+// processingContext derives a context from ctx that is also cancelled when
+// in-flight jobs are interrupted by Shutdown.
+func (w *worker) processingContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-w.processCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// requeue puts back a task interrupted by shutdown without counting it as a
+// failed attempt, so another replica picks it up.
+func (w *worker) requeue(ctx context.Context, msg *pubsub.Message, t task) {
+	ctx, cancel := context.WithTimeout(ctx, requeueTimeout)
+	defer cancel()
+	if err := t.queue(ctx, "interrupted by shutdown"); err != nil {
+		w.logger.Errorf("failed to requeue %s: %v", t, err)
+	}
+	next := &pubsub.Message{
+		Body:     msg.Body,
+		Metadata: copyMetadata(msg),
+	}
+	if err := w.topic.Send(ctx, next); err != nil {
+		w.logger.Errorf("failed to republish %s: %v", t, err)
+		nack(msg)
+		return
+	}
+	msg.Ack()
+}
+
+// Shutdown stops receiving messages and waits for in-flight jobs until ctx
+// expires, after which they are interrupted and requeued. It then closes
+// the resources the worker was given. Later calls return the error of the
+// first one.
+func (w *worker) Shutdown(ctx context.Context) error {
+	w.shutdownOnce.Do(func() {
+		w.shutdownErr = w.shutdown(ctx)
+	})
+	return w.shutdownErr
+}
+
+func (w *worker) shutdown(ctx context.Context) error {
+	close(w.stopping)
+	w.stopReceiving()
+
+	drained := make(chan struct{})
+	go func() {
+		w.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		w.logger.Warn("shutdown deadline reached, interrupting in-flight jobs")
+		w.stopProcessing()
+		// Jobs get as long as a requeue to notice, one that does not is
+		// left behind rather than holding up the shutdown.
+		select {
+		case <-drained:
+		case <-time.After(requeueTimeout):
+			w.logger.Error("in-flight jobs did not stop, closing resources anyway")
+		}
+	}
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), requeueTimeout)
+	defer cancel()
+	var errs []error
+	if err := w.srv.Shutdown(closeCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown http server: %v", err))
+	}
+	if err := w.sub.Shutdown(closeCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown subscription: %v", err))
+	}
+	if err := w.topic.Shutdown(closeCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown topic: %v", err))
+	}
+	if err := w.deadLetter.Shutdown(closeCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown dead-letter topic: %v", err))
+	}
+	if err := w.progress.Shutdown(closeCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown progress topic: %v", err))
+	}
+	if err := w.coll.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close collection: %v", err))
+	}
+	if err := w.quarantine.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close quarantine collection: %v", err))
+	}
+	if err := w.jobs.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close jobs collection: %v", err))
+	}
+	if err := w.exports.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close exports collection: %v", err))
+	}
+	if err := w.uploads.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close uploads collection: %v", err))
+	}
+	if err := w.bucket.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close bucket: %v", err))
+	}
+	w.stopProcessing()
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs[1:] {
+		w.logger.Error(err.Error())
+	}
+	return errs[0]
+}
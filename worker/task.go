@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/purge"
+	"gocloud.dev/pubsub"
+)
+
+// task is the work a message asks for. Retries, dead-lettering and requeues
+// on shutdown go through it to keep the state of the record it works on in
+// step.
+type task interface {
+	process(ctx context.Context) error
+	// queue records that the task waits for another attempt.
+	queue(ctx context.Context, reason string) error
+	fail(ctx context.Context, reason error)
+	String() string
+}
+
+func (w *worker) messageTask(msg *pubsub.Message) task {
+	id := string(msg.Body)
+	switch msg.Metadata["eventType"] {
+	case export.EventRequested:
+		return &exportTask{w: w, id: id}
+	case purge.EventRequested:
+		return &purgeTask{w: w, id: id}
+	}
+	return &jobTask{w: w, id: id}
+}
+
+// jobTask ingests the file of a job.
+type jobTask struct {
+	w  *worker
+	id string
+}
+
+func (t *jobTask) process(ctx context.Context) error {
+	return t.w.processJob(ctx, t.id)
+}
+
+func (t *jobTask) queue(ctx context.Context, reason string) error {
+	_, err := t.w.jobs.Transition(ctx, t.id, job.StateQueued, reason)
+	return err
+}
+
+func (t *jobTask) fail(ctx context.Context, reason error) {
+	t.w.failJob(ctx, t.id, reason)
+}
+
+func (t *jobTask) String() string {
+	return "job " + t.id
+}
+
+// exportTask writes an export file to the bucket.
+type exportTask struct {
+	w  *worker
+	id string
+}
+
+func (t *exportTask) process(ctx context.Context) error {
+	return t.w.processExport(ctx, t.id)
+}
+
+func (t *exportTask) queue(ctx context.Context, reason string) error {
+	_, err := t.w.exports.Transition(ctx, t.id, job.StateQueued, reason)
+	return err
+}
+
+func (t *exportTask) fail(ctx context.Context, reason error) {
+	if _, err := t.w.exports.Transition(ctx, t.id, job.StateFailed, reason.Error()); err != nil {
+		t.w.logger.Errorf("failed to mark export %s as failed: %v", t.id, err)
+	}
+}
+
+func (t *exportTask) String() string {
+	return "export " + t.id
+}
+
+// purgeTask deletes the data of a job. The job stays deleting while it is
+// retried or once it failed, with the reason as its error.
+type purgeTask struct {
+	w  *worker
+	id string
+}
+
+func (t *purgeTask) process(ctx context.Context) error {
+	return t.w.processPurge(ctx, t.id)
+}
+
+func (t *purgeTask) queue(ctx context.Context, reason string) error {
+	_, err := t.w.jobs.Transition(ctx, t.id, job.StateDeleting, reason)
+	return err
+}
+
+func (t *purgeTask) fail(ctx context.Context, reason error) {
+	if _, err := t.w.jobs.Transition(ctx, t.id, job.StateDeleting, reason.Error()); err != nil {
+		t.w.logger.Errorf("failed to record deletion failure of job %s: %v", t.id, err)
+	}
+}
+
+func (t *purgeTask) String() string {
+	return "deletion of job " + t.id
+}
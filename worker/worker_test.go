@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alvarowolfx/cloud-native-go/export"
+	"github.com/alvarowolfx/cloud-native-go/job"
+	"github.com/alvarowolfx/cloud-native-go/upload"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+// testWorker is a worker on in-memory collections, topics and bucket, with
+// subscriptions to the topics it publishes to.
+type testWorker struct {
+	*worker
+	// in is the topic the worker receives from.
+	in       *pubsub.Topic
+	queued   *pubsub.Subscription
+	dead     *pubsub.Subscription
+	progress *pubsub.Subscription
+}
+
+func newTestWorker(t *testing.T, opts Options) *testWorker {
+	t.Helper()
+	ctx := context.Background()
+	open := func(url string) *docstore.Collection {
+		coll, err := docstore.OpenCollection(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return coll
+	}
+	topic := mempubsub.NewTopic()
+	deadLetter := mempubsub.NewTopic()
+	progress := mempubsub.NewTopic()
+	tw := &testWorker{
+		in:       mempubsub.NewTopic(),
+		queued:   mempubsub.NewSubscription(topic, time.Minute),
+		dead:     mempubsub.NewSubscription(deadLetter, time.Minute),
+		progress: mempubsub.NewSubscription(progress, time.Minute),
+	}
+	// The worker receives from a topic of its own so tests read what it
+	// publishes from queued.
+	sub := mempubsub.NewSubscription(tw.in, time.Minute)
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 2
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = 1
+	}
+	if opts.RetentionInterval == 0 {
+		opts.RetentionInterval = time.Hour
+	}
+	w := New("0", make(chan error, 1),
+		open("mem://docs/id"), open("mem://quarantine/id"),
+		job.NewStore(open("mem://jobs/id")), export.NewStore(open("mem://exports/id")), upload.NewStore(open("mem://uploads/id")),
+		memblob.OpenBucket(nil), sub, topic, deadLetter, progress, opts)
+	tw.worker = w.(*worker)
+	t.Cleanup(func() {
+		tw.Shutdown(context.Background())
+		tw.queued.Shutdown(ctx)
+		tw.dead.Shutdown(ctx)
+		tw.progress.Shutdown(ctx)
+		tw.in.Shutdown(ctx)
+	})
+	return tw
+}
+
+// storeJob creates j with content as its file.
+func (w *testWorker) storeJob(t *testing.T, j *job.Job, content string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := w.jobs.Create(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.bucket.WriteAll(ctx, j.ID, []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveAll returns the messages sent to the topic of sub so far.
+func receiveAll(t *testing.T, sub *pubsub.Subscription) []*pubsub.Message {
+	t.Helper()
+	var msgs []*pubsub.Message
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		msg, err := sub.Receive(ctx)
+		cancel()
+		if err != nil {
+			return msgs
+		}
+		msg.Ack()
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// stuck leaves a job in flight that never stops.
+		stuck bool
+	}{
+		{"idle", false},
+		{"job ignoring cancellation", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorker(t, Options{})
+			if tt.stuck {
+				w.inflight.Add(1)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- w.Shutdown(ctx) }()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(requeueTimeout + 5*time.Second):
+				t.Fatal("shutdown did not return")
+			}
+			// Shutting down again does nothing more.
+			if err := w.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}